@@ -5,8 +5,10 @@
 package o2m
 
 import (
+	"regexp"
 	"strings"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	"github.com/soundbus-technologies/o2x"
 )
 
@@ -133,3 +135,41 @@ func (s *MgoAuthStore) Exist(auth o2x.Auth) bool {
 	}
 	return au.Contains(auth.GetScope())
 }
+
+// remove all auth of a client
+func (s *MgoAuthStore) RemoveByClient(clientID string) (err error) {
+	session := s.session.Clone()
+	defer session.Close()
+
+	pattern := "^" + regexp.QuoteMeta(clientID+idSplit)
+	_, err = session.DB(s.db).C(s.collection).RemoveAll(bson.M{"_id": bson.RegEx{Pattern: pattern}})
+	return
+}
+
+// remove all auth of a user, returns the number of removed auth
+func (s *MgoAuthStore) RemoveByUser(userID string) (n int, err error) {
+	session := s.session.Clone()
+	defer session.Close()
+
+	pattern := regexp.QuoteMeta(idSplit+userID) + "$"
+	info, err := session.DB(s.db).C(s.collection).RemoveAll(bson.M{"_id": bson.RegEx{Pattern: pattern}})
+	if err != nil {
+		return
+	}
+	n = info.Removed
+	return
+}
+
+// find all auth of a user
+func (s *MgoAuthStore) FindByUser(userID string) (auths []*MgoAuth, err error) {
+	session := s.session.Clone()
+	defer session.Close()
+
+	pattern := regexp.QuoteMeta(idSplit+userID) + "$"
+	err = session.DB(s.db).C(s.collection).Find(bson.M{"_id": bson.RegEx{Pattern: pattern}}).All(&auths)
+	for _, au := range auths {
+		au.GetClientID()
+		au.GetUserID()
+	}
+	return
+}
@@ -0,0 +1,308 @@
+// authors: wangoo
+// created: 2026-10-19
+// store caches
+
+package o2m
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCacheTTL        = 5 * time.Minute
+	DefaultCacheMaxEntries = 10000
+)
+
+// Cache of a store, safe for concurrent use.
+// A nil value is a not-found entry, cached only if the cache has a negative TTL.
+type Cache interface {
+	// Get the value of the key, found with a nil value for a not-found entry
+	Get(key string) (value interface{}, found bool)
+	Set(key string, value interface{})
+	Delete(key string)
+	Flush()
+	Stats() CacheStats
+}
+
+// StaleCache a cache keeping the expired entries during a stale period,
+// the stores return stale entries and refresh them in background
+type StaleCache interface {
+	Cache
+
+	// GetStale get the value of the key, stale if expired but still in the stale period
+	GetStale(key string) (value interface{}, found, stale bool)
+}
+
+// CacheStats counters of a cache
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+
+	// hits of stale entries
+	StaleHits uint64 `json:"stale_hits"`
+}
+
+// lookup a cache, stale entries only if supported by the cache
+func cacheLookup(c Cache, key string) (value interface{}, found, stale bool) {
+	if sc, ok := c.(StaleCache); ok {
+		return sc.GetStale(key)
+	}
+	value, found = c.Get(key)
+	return
+}
+
+// CacheOptions options of a LRU cache
+type CacheOptions struct {
+	// lifetime of the entries, DefaultCacheTTL if zero
+	TTL time.Duration
+
+	// lifetime of the not-found entries, not-found entries are not cached if zero
+	NegativeTTL time.Duration
+
+	// the least recently used entries are evicted above the max, DefaultCacheMaxEntries if zero, no limit if negative
+	MaxEntries int
+
+	// expired entries are kept for this period, returned by GetStale while refreshed
+	StaleTTL time.Duration
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiredAt time.Time
+
+	// end of the stale period
+	staleAt time.Time
+}
+
+// LRUCache a cache evicting the least recently used entries, and the expired entries when read
+type LRUCache struct {
+	mu      sync.Mutex
+	options CacheOptions
+	entries map[string]*list.Element
+	order   *list.List
+	stats   CacheStats
+}
+
+func NewLRUCache(options CacheOptions) *LRUCache {
+	if options.TTL == 0 {
+		options.TTL = DefaultCacheTTL
+	}
+	if options.MaxEntries == 0 {
+		options.MaxEntries = DefaultCacheMaxEntries
+	}
+	return &LRUCache{
+		options: options,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// DefaultCache a LRU cache of the default options
+func DefaultCache() Cache {
+	return NewLRUCache(CacheOptions{})
+}
+
+func (c *LRUCache) Get(key string) (value interface{}, found bool) {
+	value, found, stale := c.get(key, false)
+	if stale {
+		return nil, false
+	}
+	return
+}
+
+func (c *LRUCache) GetStale(key string) (value interface{}, found, stale bool) {
+	return c.get(key, true)
+}
+
+func (c *LRUCache) get(key string, allowStale bool) (value interface{}, found, stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return
+	}
+	entry := elem.Value.(*lruEntry)
+	now := time.Now()
+	if now.After(entry.staleAt) {
+		c.remove(elem)
+		c.stats.Evictions++
+		c.stats.Misses++
+		return
+	}
+	if now.After(entry.expiredAt) {
+		if !allowStale {
+			c.stats.Misses++
+			return nil, false, true
+		}
+		c.order.MoveToFront(elem)
+		c.stats.StaleHits++
+		return entry.value, true, true
+	}
+	c.order.MoveToFront(elem)
+	c.stats.Hits++
+	return entry.value, true, false
+}
+
+func (c *LRUCache) Set(key string, value interface{}) {
+	ttl := c.options.TTL
+	if value == nil {
+		if c.options.NegativeTTL <= 0 {
+			c.Delete(key)
+			return
+		}
+		ttl = c.options.NegativeTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiredAt := time.Now().Add(ttl)
+	staleAt := expiredAt
+	if value != nil {
+		staleAt = expiredAt.Add(c.options.StaleTTL)
+	}
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value, entry.expiredAt, entry.staleAt = value, expiredAt, staleAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiredAt: expiredAt, staleAt: staleAt})
+	for c.options.MaxEntries > 0 && c.order.Len() > c.options.MaxEntries {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRUCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).key)
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *LRUCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// NoopCache a cache storing nothing, disabling the caching of a store
+type NoopCache struct {
+	mu     sync.Mutex
+	misses uint64
+}
+
+func NewNoopCache() *NoopCache {
+	return &NoopCache{}
+}
+
+func (c *NoopCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	c.misses++
+	c.mu.Unlock()
+	return nil, false
+}
+
+func (c *NoopCache) Set(key string, value interface{}) {}
+
+func (c *NoopCache) Delete(key string) {}
+
+func (c *NoopCache) Flush() {}
+
+func (c *NoopCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Misses: c.misses}
+}
+
+// cacheGeneration bumped by each invalidation of a cache, so that a value loaded before
+// an invalidation is not cached after it, the zero value is ready to use
+type cacheGeneration struct {
+	mu  sync.Mutex
+	gen uint64
+}
+
+// current generation, read before loading a value
+func (g *cacheGeneration) current() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gen
+}
+
+// invalidate bump the generation and evict
+func (g *cacheGeneration) invalidate(evict func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gen++
+	evict()
+}
+
+// setIf cache a value loaded at the generation, unless invalidated since
+func (g *cacheGeneration) setIf(gen uint64, set func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.gen != gen {
+		return false
+	}
+	set()
+	return true
+}
+
+// StoreOption option of a client or user store
+type StoreOption func(*storeOptions)
+
+type storeOptions struct {
+	cache      Cache
+	scopeCache Cache
+}
+
+func newStoreOptions(opts []StoreOption) *storeOptions {
+	o := &storeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.cache == nil {
+		o.cache = DefaultCache()
+	}
+	if o.scopeCache == nil {
+		o.scopeCache = DefaultCache()
+	}
+	return o
+}
+
+// WithCache set the cache of the clients or the users, NewNoopCache disables caching
+func WithCache(cache Cache) StoreOption {
+	return func(o *storeOptions) {
+		o.cache = cache
+	}
+}
+
+// WithScopeCache set the cache of the effective scopes of the users
+func WithScopeCache(cache Cache) StoreOption {
+	return func(o *storeOptions) {
+		o.scopeCache = cache
+	}
+}
@@ -0,0 +1,102 @@
+// authors: wangoo
+// created: 2026-10-19
+// cache test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(CacheOptions{MaxEntries: 2})
+	c.Set("a", 1)
+	c.Set("b", 2)
+	v, found := c.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, v)
+
+	// b is the least recently used
+	c.Set("c", 3)
+	_, found = c.Get("b")
+	assert.False(t, found)
+	_, found = c.Get("c")
+	assert.True(t, found)
+
+	c.Delete("c")
+	_, found = c.Get("c")
+	assert.False(t, found)
+
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 2, Evictions: 1, Entries: 1}, c.Stats())
+
+	c.Flush()
+	assert.Equal(t, 0, c.Stats().Entries)
+}
+
+func TestLRUCacheExpiration(t *testing.T) {
+	c := NewLRUCache(CacheOptions{TTL: 10 * time.Millisecond, NegativeTTL: time.Minute})
+	c.Set("a", 1)
+	c.Set("missing", nil)
+
+	v, found := c.Get("missing")
+	assert.True(t, found)
+	assert.Nil(t, v)
+
+	time.Sleep(20 * time.Millisecond)
+	_, found = c.Get("a")
+	assert.False(t, found)
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+
+	// not-found entries are not cached without a negative ttl
+	c = NewLRUCache(CacheOptions{})
+	c.Set("missing", nil)
+	_, found = c.Get("missing")
+	assert.False(t, found)
+}
+
+func TestLRUCacheStale(t *testing.T) {
+	c := NewLRUCache(CacheOptions{TTL: 10 * time.Millisecond, StaleTTL: time.Minute})
+	c.Set("a", 1)
+	time.Sleep(20 * time.Millisecond)
+
+	_, found := c.Get("a")
+	assert.False(t, found)
+	v, found, stale := c.GetStale("a")
+	assert.True(t, found)
+	assert.True(t, stale)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, uint64(1), c.Stats().StaleHits)
+
+	// refreshed
+	c.Set("a", 2)
+	v, found, stale = cacheLookup(c, "a")
+	assert.Equal(t, 2, v)
+	assert.True(t, found)
+	assert.False(t, stale)
+
+	// caches without stale entries
+	v, found, stale = cacheLookup(NewNoopCache(), "a")
+	assert.False(t, found)
+	assert.False(t, stale)
+}
+
+func TestNoopCache(t *testing.T) {
+	var c Cache = NewNoopCache()
+	c.Set("a", 1)
+	_, found := c.Get("a")
+	assert.False(t, found)
+	assert.Equal(t, CacheStats{Misses: 1}, c.Stats())
+}
+
+func TestStoreOptions(t *testing.T) {
+	o := newStoreOptions(nil)
+	assert.NotNil(t, o.cache)
+	assert.NotNil(t, o.scopeCache)
+	assert.False(t, o.cache == o.scopeCache)
+
+	noop := NewNoopCache()
+	o = newStoreOptions([]StoreOption{WithCache(noop)})
+	assert.True(t, o.cache == Cache(noop))
+}
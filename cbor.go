@@ -0,0 +1,137 @@
+// authors: wangoo
+// created: 2026-10-19
+// minimal cbor (RFC 7049) decoder for webauthn attestation and cose keys
+
+package o2m
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const cborMaxDepth = 16
+
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+// decodeCBOR decode one item and return the remaining bytes.
+// Integers decode to int64, byte strings to []byte, text to string,
+// arrays to []interface{} and maps to map[interface{}]interface{}.
+func decodeCBOR(data []byte) (v interface{}, rest []byte, err error) {
+	return decodeCBORItem(data, 0)
+}
+
+func cborArgument(data []byte) (major byte, arg uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, nil, ErrInvalidCBOR
+	}
+	major = data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		if len(data) < 1 {
+			return 0, 0, nil, ErrInvalidCBOR
+		}
+		arg, data = uint64(data[0]), data[1:]
+	case info == 25:
+		if len(data) < 2 {
+			return 0, 0, nil, ErrInvalidCBOR
+		}
+		arg, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26:
+		if len(data) < 4 {
+			return 0, 0, nil, ErrInvalidCBOR
+		}
+		arg, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27:
+		if len(data) < 8 {
+			return 0, 0, nil, ErrInvalidCBOR
+		}
+		arg, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		// indefinite lengths are not used by authenticators
+		return 0, 0, nil, ErrInvalidCBOR
+	}
+	return major, arg, data, nil
+}
+
+func decodeCBORItem(data []byte, depth int) (v interface{}, rest []byte, err error) {
+	if depth > cborMaxDepth {
+		return nil, nil, ErrInvalidCBOR
+	}
+	major, arg, data, err := cborArgument(data)
+	if err != nil {
+		return
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, ErrInvalidCBOR
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, ErrInvalidCBOR
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+		b := data[:arg]
+		if major == 3 {
+			return string(b), data[arg:], nil
+		}
+		return append([]byte(nil), b...), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return
+			}
+			arr = append(arr, item)
+		}
+		return arr, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, ErrInvalidCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, item interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrInvalidCBOR
+			}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return
+			}
+			m[key] = item
+		}
+		return m, data, nil
+	case 6:
+		// tag, keep the tagged item
+		return decodeCBORItem(data, depth+1)
+	case 7:
+		switch arg {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+	}
+	return nil, nil, ErrInvalidCBOR
+}
@@ -4,9 +4,10 @@
 package o2m
 
 import (
-	"github.com/patrickmn/go-cache"
+	"github.com/golang/glog"
 	"github.com/soundbus-technologies/o2x"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	"gopkg.in/oauth2.v3"
 	"time"
 )
@@ -16,24 +17,19 @@ const (
 	DefaultOauth2ClientCollection = "client"
 )
 
-var (
-	clientCache *cache.Cache
-)
-
-func init() {
-	// Create a cache with a default expiration time of 5 minutes, and which
-	// purges expired items every 10 minutes
-	clientCache = cache.New(5*time.Minute, 10*time.Minute)
+func (cs *MongoClientStore) addClientCache(cli oauth2.ClientInfo) {
+	cs.cache.Set(cli.GetID(), cli)
 }
 
-func addClientCache(cli oauth2.ClientInfo) {
-	clientCache.Add(cli.GetID(), cli, cache.DefaultExpiration)
+func (cs *MongoClientStore) removeClientCache(id string) {
+	cs.cacheGen.invalidate(func() { cs.cache.Delete(id) })
 }
 
-func getClientCache(id string) (cli oauth2.ClientInfo) {
-	if c, found := clientCache.Get(id); found {
+// getClientCache returns found with a nil client if cached as not found, stale if to be refreshed
+func (cs *MongoClientStore) getClientCache(id string) (cli oauth2.ClientInfo, found, stale bool) {
+	c, found, stale := cacheLookup(cs.cache, id)
+	if found && c != nil {
 		cli = c.(oauth2.ClientInfo)
-		return
 	}
 	return
 }
@@ -43,6 +39,31 @@ type MongoClientStore struct {
 	db         string       //数据库
 	collection string       //集合
 	session    *mgo.Session //session
+	bus        *InvalidationBus
+	cache      Cache
+	cacheGen   cacheGeneration
+	flight     flightGroup
+}
+
+// ClientStatus status of a client, empty means active
+type ClientStatus string
+
+const (
+	ClientStatusActive    ClientStatus = "active"
+	ClientStatusSuspended ClientStatus = "suspended"
+	ClientStatusDeleted   ClientStatus = "deleted"
+)
+
+// ClientRevoker remove all tokens or consents granted to a client
+type ClientRevoker interface {
+	RemoveByClient(clientID string) error
+}
+
+// ClientTokenExp token lifetimes configured for one client, zero means using the manager default
+type ClientTokenExp interface {
+	GetAccessTokenExp() time.Duration
+	GetRefreshTokenExp() time.Duration
+	GetCodeExp() time.Duration
 }
 
 type Oauth2Client struct {
@@ -52,6 +73,25 @@ type Oauth2Client struct {
 	Scopes     []string           `bson:"scopes" json:"scopes"`           //包含的scope集合
 	GrantTypes []oauth2.GrantType `bson:"grant_types" json:"grant_types"` //包含的授权方式
 	UserID     string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+
+	// metadata displayed on consent screens
+	Name        string   `bson:"name,omitempty" json:"name,omitempty"`
+	LogoURI     string   `bson:"logo_uri,omitempty" json:"logo_uri,omitempty"`
+	HomepageURI string   `bson:"homepage_uri,omitempty" json:"homepage_uri,omitempty"`
+	Contacts    []string `bson:"contacts,omitempty" json:"contacts,omitempty"`
+
+	// token lifetimes of the client, zero means using the manager default
+	AccessTokenExp  time.Duration `bson:"access_token_exp,omitempty" json:"access_token_exp,omitempty"`
+	RefreshTokenExp time.Duration `bson:"refresh_token_exp,omitempty" json:"refresh_token_exp,omitempty"`
+	CodeExp         time.Duration `bson:"code_exp,omitempty" json:"code_exp,omitempty"`
+
+	// client authentication at the token endpoint, empty means client_secret_basic
+	TokenEndpointAuthMethod string         `bson:"token_endpoint_auth_method,omitempty" json:"token_endpoint_auth_method,omitempty"`
+	JWKS                    *JSONWebKeySet `bson:"jwks,omitempty" json:"jwks,omitempty"`
+	JWKSURI                 string         `bson:"jwks_uri,omitempty" json:"jwks_uri,omitempty"`
+
+	Status          ClientStatus `bson:"status,omitempty" json:"status,omitempty"`
+	StatusUpdatedAt time.Time    `bson:"status_updated_at,omitempty" json:"status_updated_at,omitempty"`
 }
 
 func (c *Oauth2Client) GetID() string {
@@ -72,15 +112,56 @@ func (c *Oauth2Client) GetGrantTypes() []oauth2.GrantType {
 func (c *Oauth2Client) GetUserID() string {
 	return c.UserID
 }
+func (c *Oauth2Client) GetStatus() ClientStatus {
+	if c.Status == "" {
+		return ClientStatusActive
+	}
+	return c.Status
+}
+
+// Active whether the client is allowed to use
+func (c *Oauth2Client) Active() bool {
+	return c.GetStatus() == ClientStatusActive
+}
+
+func (c *Oauth2Client) GetTokenEndpointAuthMethod() string {
+	if c.TokenEndpointAuthMethod == "" {
+		return AuthMethodClientSecretBasic
+	}
+	return c.TokenEndpointAuthMethod
+}
+
+func (c *Oauth2Client) GetName() string {
+	return c.Name
+}
+func (c *Oauth2Client) GetLogoURI() string {
+	return c.LogoURI
+}
+func (c *Oauth2Client) GetHomepageURI() string {
+	return c.HomepageURI
+}
+func (c *Oauth2Client) GetContacts() []string {
+	return c.Contacts
+}
+func (c *Oauth2Client) GetAccessTokenExp() time.Duration {
+	return c.AccessTokenExp
+}
+func (c *Oauth2Client) GetRefreshTokenExp() time.Duration {
+	return c.RefreshTokenExp
+}
+func (c *Oauth2Client) GetCodeExp() time.Duration {
+	return c.CodeExp
+}
 
 /*
 新建一个client的mongodb链接
 */
-func NewClientStore(session *mgo.Session, db string, collection string) (clientStore *MongoClientStore) {
+func NewClientStore(session *mgo.Session, db string, collection string, opts ...StoreOption) (clientStore *MongoClientStore) {
 	if session == nil {
 		panic("session cannot be nil")
 	}
-	clientStore = &MongoClientStore{session: session, db: db, collection: collection}
+	options := newStoreOptions(opts)
+	clientStore = &MongoClientStore{session: session, db: db, collection: collection, cache: options.cache}
 	if clientStore.db == "" {
 		clientStore.db = DefaultOauth2ClientDb
 	}
@@ -90,26 +171,87 @@ func NewClientStore(session *mgo.Session, db string, collection string) (clientS
 	return
 }
 
-// GetByID according to the ID for the client information
-func (cs *MongoClientStore) GetByID(id string) (cli oauth2.ClientInfo, err error) {
-	//先从缓存查询
-	if cli = getClientCache(id); cli != nil {
+// CacheStats statistics of the client cache
+func (cs *MongoClientStore) CacheStats() CacheStats {
+	return cs.cache.Stats()
+}
+
+// SetInvalidationBus publish cache evictions to other instances and evict the ones published by them
+func (cs *MongoClientStore) SetInvalidationBus(bus *InvalidationBus) {
+	cs.bus = bus
+	bus.Subscribe(InvalidateClient, cs.removeClientCache)
+}
+
+func (cs *MongoClientStore) publish(id string) {
+	if cs.bus == nil {
 		return
 	}
+	if err := cs.bus.Publish(InvalidateClient, id); err != nil {
+		glog.Warningf("publish client %v invalidation error: %v", id, err)
+	}
+}
 
-	session := cs.session.Clone()
-	defer session.Close()
+// GetByID according to the ID for the client information.
+// Concurrent loads of a client are coalesced, stale cached clients are returned while refreshed in background.
+func (cs *MongoClientStore) GetByID(id string) (cli oauth2.ClientInfo, err error) {
+	//先从缓存查询
+	if cli, found, stale := cs.getClientCache(id); found {
+		if stale {
+			cs.flight.Refresh(id, cs.loader(id))
+		}
+		if cli == nil {
+			return nil, mgo.ErrNotFound
+		}
+		return checkClientStatus(cli)
+	}
 
-	client := &Oauth2Client{}
-	c := session.DB(cs.db).C(cs.collection)
-	query := c.FindId(id)
-	err = query.One(client)
+	v, err, _ := cs.flight.Do(id, cs.loader(id))
 	if err != nil {
 		return nil, err
 	}
+	cli, ok := v.(oauth2.ClientInfo)
+	if !ok {
+		return nil, errFlightResult
+	}
+	return checkClientStatus(cli)
+}
+
+// loader load a client from the database into the cache,
+// the result is not cached if the client has been invalidated while loading
+func (cs *MongoClientStore) loader(id string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		gen := cs.cacheGen.current()
+		session := cs.session.Clone()
+		defer session.Close()
+
+		client := &Oauth2Client{}
+		c := session.DB(cs.db).C(cs.collection)
+		err := c.FindId(id).One(client)
+		if err == mgo.ErrNotFound {
+			cs.cacheGen.setIf(gen, func() { cs.cache.Set(id, nil) })
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	addClientCache(client)
-	return client, nil
+		cs.cacheGen.setIf(gen, func() { cs.addClientCache(client) })
+		return client, nil
+	}
+}
+
+// refuse the clients which are not active
+func checkClientStatus(cli oauth2.ClientInfo) (oauth2.ClientInfo, error) {
+	client, ok := cli.(*Oauth2Client)
+	if !ok {
+		return cli, nil
+	}
+	switch client.GetStatus() {
+	case ClientStatusActive:
+		return cli, nil
+	case ClientStatusSuspended:
+		return nil, ErrClientSuspended
+	}
+	return nil, ErrClientDeleted
 }
 
 // Add a client info
@@ -129,6 +271,130 @@ func (cs *MongoClientStore) Set(id string, cli oauth2.ClientInfo) (err error) {
 		client.Scopes = o2ClientInfo.GetScopes()
 		client.GrantTypes = o2ClientInfo.GetGrantTypes()
 	}
-	addClientCache(client)
-	return c.Insert(client)
+	if o2Client, ok := cli.(*Oauth2Client); ok {
+		client.Name = o2Client.Name
+		client.LogoURI = o2Client.LogoURI
+		client.HomepageURI = o2Client.HomepageURI
+		client.Contacts = o2Client.Contacts
+		client.Status = o2Client.Status
+		client.TokenEndpointAuthMethod = o2Client.TokenEndpointAuthMethod
+		client.JWKS = o2Client.JWKS
+		client.JWKSURI = o2Client.JWKSURI
+	}
+	if exp, ok := cli.(ClientTokenExp); ok {
+		client.AccessTokenExp = exp.GetAccessTokenExp()
+		client.RefreshTokenExp = exp.GetRefreshTokenExp()
+		client.CodeExp = exp.GetCodeExp()
+	}
+	if err = c.Insert(client); err != nil {
+		if mgo.IsDup(err) {
+			err = ErrDuplicateClientID
+		}
+		return
+	}
+	cs.addClientCache(client)
+	cs.publish(client.ID)
+	return
+}
+
+// UpdateStatus change the status of a client, then revoke its tokens and consents by the given revokers
+func (cs *MongoClientStore) UpdateStatus(id string, status ClientStatus, revokers ...ClientRevoker) (err error) {
+	switch status {
+	case ClientStatusActive, ClientStatusSuspended, ClientStatusDeleted:
+	default:
+		return ErrInvalidClientStatus
+	}
+	session := cs.session.Clone()
+	defer session.Close()
+
+	c := session.DB(cs.db).C(cs.collection)
+	bs := bson.M{"status": status, "status_updated_at": time.Now()}
+	err = c.UpdateId(id, bson.M{"$set": bs})
+	cs.removeClientCache(id)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = o2x.ErrNotFound
+		}
+		return
+	}
+	cs.publish(id)
+	glog.Infof("update client %v status %v", id, status)
+
+	for _, revoker := range revokers {
+		if err = revoker.RemoveByClient(id); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Suspend a client, optionally revoking its tokens and consents
+func (cs *MongoClientStore) Suspend(id string, revokers ...ClientRevoker) error {
+	return cs.UpdateStatus(id, ClientStatusSuspended, revokers...)
+}
+
+// Delete mark a client deleted and keep the document for audit, optionally revoking its tokens and consents
+func (cs *MongoClientStore) Delete(id string, revokers ...ClientRevoker) error {
+	return cs.UpdateStatus(id, ClientStatusDeleted, revokers...)
+}
+
+// Activate a suspended or deleted client
+func (cs *MongoClientStore) Activate(id string) error {
+	return cs.UpdateStatus(id, ClientStatusActive)
+}
+
+// ids of the clients owned by a user
+func clientIDsByUser(c *mgo.Collection, userID string) (ids []string, err error) {
+	var clients []Oauth2Client
+	err = c.Find(bson.M{"user_id": userID}).Select(bson.M{"_id": 1}).All(&clients)
+	for _, client := range clients {
+		ids = append(ids, client.ID)
+	}
+	return
+}
+
+// FindByUser find the clients owned by a user
+func (cs *MongoClientStore) FindByUser(userID string) (clients []*Oauth2Client, err error) {
+	session := cs.session.Clone()
+	defer session.Close()
+
+	err = session.DB(cs.db).C(cs.collection).Find(bson.M{"user_id": userID}).All(&clients)
+	return
+}
+
+// RemoveByUser remove the clients owned by a user, returns the ids of the removed clients
+func (cs *MongoClientStore) RemoveByUser(userID string) (ids []string, err error) {
+	session := cs.session.Clone()
+	defer session.Close()
+
+	c := session.DB(cs.db).C(cs.collection)
+	if ids, err = clientIDsByUser(c, userID); err != nil || len(ids) == 0 {
+		return
+	}
+	_, err = c.RemoveAll(bson.M{"_id": bson.M{"$in": ids}, "user_id": userID})
+	for _, id := range ids {
+		cs.removeClientCache(id)
+		cs.publish(id)
+	}
+	glog.Infof("remove user %v clients %v", userID, ids)
+	return
+}
+
+// AnonymizeByUser remove the owner of the clients owned by a user, returns the ids of the clients
+func (cs *MongoClientStore) AnonymizeByUser(userID string) (ids []string, err error) {
+	session := cs.session.Clone()
+	defer session.Close()
+
+	c := session.DB(cs.db).C(cs.collection)
+	if ids, err = clientIDsByUser(c, userID); err != nil || len(ids) == 0 {
+		return
+	}
+	_, err = c.UpdateAll(bson.M{"_id": bson.M{"$in": ids}, "user_id": userID},
+		bson.M{"$unset": bson.M{"user_id": ""}})
+	for _, id := range ids {
+		cs.removeClientCache(id)
+		cs.publish(id)
+	}
+	glog.Infof("anonymize user %v clients %v", userID, ids)
+	return
 }
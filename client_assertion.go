@@ -0,0 +1,462 @@
+// authors: wangoo
+// created: 2026-10-19
+// private_key_jwt and client_secret_jwt client authentication
+
+package o2m
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/oauth2.v3"
+	oauth2errors "gopkg.in/oauth2.v3/errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodClientSecretJWT   = "client_secret_jwt"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+
+	DefaultClientAssertionCollection = "client_assertion"
+	DefaultClientAssertionLeeway     = 30 * time.Second
+)
+
+var (
+	ErrInvalidClientAssertion  = errors.New("invalid client assertion")
+	ErrClientAssertionReplayed = errors.New("client assertion replayed")
+)
+
+// audience of a jwt, either a string or an array of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+func (a jwtAudience) contains(values []string) bool {
+	for _, aud := range a {
+		for _, v := range values {
+			if aud == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClientAssertionClaims claims of a client_assertion jwt
+type ClientAssertionClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// a compact serialized jws, signature not verified
+type parsedJWT struct {
+	header       jwtHeader
+	claims       ClientAssertionClaims
+	signingInput string
+	signature    []byte
+}
+
+func parseJWT(token string) (jwt *parsedJWT, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidClientAssertion
+	}
+	jwt = &parsedJWT{signingInput: parts[0] + "." + parts[1]}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidClientAssertion
+	}
+	if err = json.Unmarshal(b, &jwt.header); err != nil {
+		return nil, ErrInvalidClientAssertion
+	}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidClientAssertion
+	}
+	if err = json.Unmarshal(b, &jwt.claims); err != nil {
+		return nil, ErrInvalidClientAssertion
+	}
+	if jwt.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, ErrInvalidClientAssertion
+	}
+	return
+}
+
+func jwtHash(alg string) (crypto.Hash, error) {
+	if len(alg) != 5 {
+		return 0, fmt.Errorf("unsupported alg %v", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported alg %v", alg)
+}
+
+func isHMACAlg(alg string) bool {
+	return strings.HasPrefix(alg, "HS")
+}
+
+// verify the jws signature by the alg in header, key is []byte for HS*, or a public key
+func (jwt *parsedJWT) verify(key interface{}) error {
+	alg := jwt.header.Alg
+	hash, err := jwtHash(alg)
+	if err != nil {
+		return err
+	}
+
+	if isHMACAlg(alg) {
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return ErrInvalidClientAssertion
+		}
+		var mac = hmac.New(sha256.New, secret)
+		switch hash {
+		case crypto.SHA384:
+			mac = hmac.New(sha512.New384, secret)
+		case crypto.SHA512:
+			mac = hmac.New(sha512.New, secret)
+		}
+		mac.Write([]byte(jwt.signingInput))
+		if !hmac.Equal(mac.Sum(nil), jwt.signature) {
+			return ErrInvalidClientAssertion
+		}
+		return nil
+	}
+
+	h := hash.New()
+	h.Write([]byte(jwt.signingInput))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, jwt.signature)
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, jwt.signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(jwt.signature) != 2*size || pub.Curve.Params().BitSize != ecdsaBitSize(hash) {
+			return ErrInvalidClientAssertion
+		}
+		r := new(big.Int).SetBytes(jwt.signature[:size])
+		s := new(big.Int).SetBytes(jwt.signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidClientAssertion
+		}
+		return nil
+	}
+	return ErrInvalidClientAssertion
+}
+
+func ecdsaBitSize(hash crypto.Hash) int {
+	switch hash {
+	case crypto.SHA384:
+		return 384
+	case crypto.SHA512:
+		return 521
+	}
+	return 256
+}
+
+// check the registered claims, not checking the signature
+func (claims *ClientAssertionClaims) validate(clientID string, audience []string, now time.Time, leeway time.Duration) error {
+	if claims.Issuer != clientID || claims.Subject != clientID {
+		return ErrInvalidClientAssertion
+	}
+	if !claims.Audience.contains(audience) {
+		return ErrInvalidClientAssertion
+	}
+	if claims.ExpiresAt == 0 || now.Add(-leeway).Unix() > claims.ExpiresAt {
+		return ErrInvalidClientAssertion
+	}
+	if claims.NotBefore != 0 && now.Add(leeway).Unix() < claims.NotBefore {
+		return ErrInvalidClientAssertion
+	}
+	if claims.ID == "" {
+		return ErrInvalidClientAssertion
+	}
+	return nil
+}
+
+// jti already used by a client, removed by the ttl index after the assertion expires
+type clientAssertionJTI struct {
+	ID        string    `bson:"_id"`
+	ExpiredAt time.Time `bson:"ExpiredAt"`
+}
+
+// ClientAssertionAuthenticator authenticate clients by client_assertion jwt (RFC 7523),
+// using the client secret for client_secret_jwt and the client key set for private_key_jwt
+type ClientAssertionAuthenticator struct {
+	session     *mgo.Session
+	db          string
+	collection  string
+	clientStore oauth2.ClientStore
+	fetcher     JWKSFetcher
+	audience    []string
+	leeway      time.Duration
+}
+
+// NewClientAssertionAuthenticator create an authenticator, audience is usually the token endpoint url,
+// used jti values are stored in the collection to prevent replay
+func NewClientAssertionAuthenticator(session *mgo.Session, db, collection string, cs oauth2.ClientStore, fetcher JWKSFetcher, audience ...string) (a *ClientAssertionAuthenticator) {
+	if session == nil {
+		panic("session cannot be nil")
+	}
+	if len(audience) == 0 {
+		panic("audience required")
+	}
+	a = &ClientAssertionAuthenticator{
+		session:     session,
+		db:          db,
+		collection:  collection,
+		clientStore: cs,
+		fetcher:     fetcher,
+		audience:    audience,
+		leeway:      DefaultClientAssertionLeeway,
+	}
+	if a.db == "" {
+		a.db = DefaultOauth2ClientDb
+	}
+	if a.collection == "" {
+		a.collection = DefaultClientAssertionCollection
+	}
+	if a.fetcher == nil {
+		a.fetcher = NewHTTPJWKSFetcher(DefaultJWKSCacheExp)
+	}
+
+	err := session.DB(a.db).C(a.collection).EnsureIndex(mgo.Index{
+		Key:         []string{"ExpiredAt"},
+		ExpireAfter: time.Second * 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// SetLeeway set the allowed clock skew when checking exp and nbf
+func (a *ClientAssertionAuthenticator) SetLeeway(leeway time.Duration) {
+	a.leeway = leeway
+}
+
+// Authenticate verify the client assertion, clientID is optional and read from the assertion subject if empty
+func (a *ClientAssertionAuthenticator) Authenticate(clientID, assertionType, assertion string) (cli oauth2.ClientInfo, err error) {
+	if assertionType != ClientAssertionTypeJWTBearer {
+		return nil, ErrInvalidClientAssertion
+	}
+	jwt, err := parseJWT(assertion)
+	if err != nil {
+		return
+	}
+	if clientID == "" {
+		clientID = jwt.claims.Subject
+	}
+	if err = jwt.claims.validate(clientID, a.audience, time.Now(), a.leeway); err != nil {
+		return
+	}
+
+	cli, err = a.clientStore.GetByID(clientID)
+	if err != nil {
+		return
+	}
+	key, err := a.verifyKey(cli, jwt)
+	if err != nil {
+		return nil, err
+	}
+	if err = jwt.verify(key); err != nil {
+		glog.Infof("client %v assertion signature invalid: %v", clientID, err)
+		return nil, ErrInvalidClientAssertion
+	}
+
+	if err = a.useJTI(clientID, jwt.claims); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// the key used to verify the assertion, according to the client auth method
+func (a *ClientAssertionAuthenticator) verifyKey(cli oauth2.ClientInfo, jwt *parsedJWT) (key interface{}, err error) {
+	method := AuthMethodClientSecretBasic
+	client, ok := cli.(*Oauth2Client)
+	if ok {
+		method = client.GetTokenEndpointAuthMethod()
+	}
+
+	if isHMACAlg(jwt.header.Alg) {
+		if method != AuthMethodClientSecretJWT {
+			return nil, ErrInvalidClientAssertion
+		}
+		return []byte(cli.GetSecret()), nil
+	}
+	if method != AuthMethodPrivateKeyJWT {
+		return nil, ErrInvalidClientAssertion
+	}
+
+	set := client.JWKS
+	if set == nil && client.JWKSURI != "" {
+		if set, err = a.fetcher.FetchJWKS(client.JWKSURI); err != nil {
+			glog.Infof("fetch client %v jwks error: %v", client.ID, err)
+			return nil, ErrInvalidClientAssertion
+		}
+		key = matchJWK(set, jwt.header)
+		// the client may have rotated its keys since the set was cached
+		if refresher, ok := a.fetcher.(JWKSRefresher); ok && key == nil {
+			if set, err = refresher.RefreshJWKS(client.JWKSURI); err != nil {
+				glog.Infof("refresh client %v jwks error: %v", client.ID, err)
+				return nil, ErrInvalidClientAssertion
+			}
+			key = matchJWK(set, jwt.header)
+		}
+	} else {
+		key = matchJWK(set, jwt.header)
+	}
+	if key == nil {
+		return nil, ErrInvalidClientAssertion
+	}
+	return key, nil
+}
+
+// the key type of a jws alg
+func jwkType(alg string) string {
+	switch alg[:2] {
+	case "RS", "PS":
+		return "RSA"
+	case "ES":
+		return "EC"
+	}
+	return ""
+}
+
+// the public key of the set matching kid, kty, use and alg of the header, nil if none
+func matchJWK(set *JSONWebKeySet, header jwtHeader) interface{} {
+	if set == nil || len(header.Alg) < 2 {
+		return nil
+	}
+	kty := jwkType(header.Alg)
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if header.Kid != "" && k.Kid != header.Kid {
+			continue
+		}
+		if k.Kty != kty {
+			continue
+		}
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Alg != "" && k.Alg != header.Alg {
+			continue
+		}
+		if key, err := k.PublicKey(); err == nil {
+			return key
+		}
+	}
+	return nil
+}
+
+func (a *ClientAssertionAuthenticator) useJTI(clientID string, claims ClientAssertionClaims) (err error) {
+	session := a.session.Clone()
+	defer session.Close()
+
+	jti := &clientAssertionJTI{
+		ID:        buildAuthID(clientID, claims.ID),
+		ExpiredAt: time.Unix(claims.ExpiresAt, 0).Add(a.leeway),
+	}
+	err = session.DB(a.db).C(a.collection).Insert(jti)
+	if mgo.IsDup(err) {
+		return ErrClientAssertionReplayed
+	}
+	return
+}
+
+// ClientInfoHandler get client id and secret from the request, can be set as the server ClientInfoHandler.
+// A request with client_assertion is authenticated by the assertion and gets the stored client secret,
+// other requests fall back to basic auth and then form values, and require a secret
+// of a client not registered with a jwt auth method.
+func (a *ClientAssertionAuthenticator) ClientInfoHandler(r *http.Request) (clientID, clientSecret string, err error) {
+	if assertion := r.FormValue("client_assertion"); assertion != "" {
+		cli, authErr := a.Authenticate(r.FormValue("client_id"), r.FormValue("client_assertion_type"), assertion)
+		if authErr != nil {
+			glog.Infof("client assertion authentication failed: %v", authErr)
+			return "", "", oauth2errors.ErrInvalidClient
+		}
+		return cli.GetID(), cli.GetSecret(), nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if ok {
+		clientID, clientSecret = username, password
+	} else {
+		clientID = r.FormValue("client_id")
+		clientSecret = r.FormValue("client_secret")
+	}
+	if clientID == "" || clientSecret == "" {
+		return "", "", oauth2errors.ErrInvalidClient
+	}
+	if err = a.checkSecretMethod(clientID); err != nil {
+		return "", "", err
+	}
+	return
+}
+
+// a client registered with a jwt auth method can only authenticate by client_assertion
+func (a *ClientAssertionAuthenticator) checkSecretMethod(clientID string) error {
+	cli, err := a.clientStore.GetByID(clientID)
+	if err != nil {
+		return oauth2errors.ErrInvalidClient
+	}
+	if client, ok := cli.(*Oauth2Client); ok {
+		switch client.GetTokenEndpointAuthMethod() {
+		case AuthMethodClientSecretJWT, AuthMethodPrivateKeyJWT:
+			glog.Infof("client %v requires client_assertion", clientID)
+			return oauth2errors.ErrInvalidClient
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+// authors: wangoo
+// created: 2026-10-19
+// client assertion test
+
+package o2m
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/oauth2.v3/errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signTestJWT(t *testing.T, header jwtHeader, claims ClientAssertionClaims, sign func(input []byte) []byte) string {
+	h, err := json.Marshal(header)
+	assert.Nil(t, err)
+	c, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	input := b64(h) + "." + b64(c)
+	return input + "." + b64(sign([]byte(input)))
+}
+
+type staticJWKSFetcher map[string]*JSONWebKeySet
+
+func (f staticJWKSFetcher) FetchJWKS(uri string) (*JSONWebKeySet, error) {
+	return f[uri], nil
+}
+
+func TestClientAssertionVerify(t *testing.T) {
+	now := time.Now()
+	claims := ClientAssertionClaims{
+		Issuer:    "c1",
+		Subject:   "c1",
+		Audience:  jwtAudience{"https://auth/token"},
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		ID:        "jti1",
+	}
+	audience := []string{"https://auth/token"}
+
+	// private_key_jwt RS256 with jwks_uri
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	token := signTestJWT(t, jwtHeader{Alg: "RS256", Kid: "k1"}, claims, func(input []byte) []byte {
+		d := sha256.Sum256(input)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, d[:])
+		assert.Nil(t, err)
+		return sig
+	})
+	client := &Oauth2Client{
+		ID:                      "c1",
+		TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT,
+		JWKSURI:                 "https://c1/jwks",
+	}
+	a := &ClientAssertionAuthenticator{fetcher: staticJWKSFetcher{
+		"https://c1/jwks": {Keys: []JSONWebKey{{
+			Kty: "RSA",
+			Kid: "k1",
+			N:   b64(rsaKey.N.Bytes()),
+			E:   b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}}},
+	}}
+
+	jwt, err := parseJWT(token)
+	assert.Nil(t, err)
+	assert.Nil(t, jwt.claims.validate("c1", audience, now, 0))
+	key, err := a.verifyKey(client, jwt)
+	assert.Nil(t, err)
+	assert.Nil(t, jwt.verify(key))
+
+	// wrong client, audience or expired
+	assert.NotNil(t, jwt.claims.validate("c2", audience, now, 0))
+	assert.NotNil(t, jwt.claims.validate("c1", []string{"https://other"}, now, 0))
+	assert.NotNil(t, jwt.claims.validate("c1", audience, now.Add(2*time.Minute), 0))
+
+	// secret jwt not allowed for private_key_jwt client
+	hsToken := signTestJWT(t, jwtHeader{Alg: "HS256"}, claims, func(input []byte) []byte {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(input)
+		return mac.Sum(nil)
+	})
+	jwt, err = parseJWT(hsToken)
+	assert.Nil(t, err)
+	_, err = a.verifyKey(client, jwt)
+	assert.Equal(t, ErrInvalidClientAssertion, err)
+
+	// client_secret_jwt HS256
+	client = &Oauth2Client{ID: "c1", Secret: "secret", TokenEndpointAuthMethod: AuthMethodClientSecretJWT}
+	key, err = a.verifyKey(client, jwt)
+	assert.Nil(t, err)
+	assert.Nil(t, jwt.verify(key))
+	client.Secret = "other"
+	key, _ = a.verifyKey(client, jwt)
+	assert.NotNil(t, jwt.verify(key))
+
+	// private_key_jwt ES256 with inline jwks
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	esToken := signTestJWT(t, jwtHeader{Alg: "ES256"}, claims, func(input []byte) []byte {
+		d := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, d[:])
+		assert.Nil(t, err)
+		sig := make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+		return sig
+	})
+	client = &Oauth2Client{
+		ID:                      "c1",
+		TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT,
+		JWKS: &JSONWebKeySet{Keys: []JSONWebKey{{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   b64(ecKey.X.Bytes()),
+			Y:   b64(ecKey.Y.Bytes()),
+		}}},
+	}
+	jwt, err = parseJWT(esToken)
+	assert.Nil(t, err)
+	key, err = a.verifyKey(client, jwt)
+	assert.Nil(t, err)
+	assert.Nil(t, jwt.verify(key))
+
+	_, err = parseJWT("a.b")
+	assert.Equal(t, ErrInvalidClientAssertion, err)
+}
+
+func TestClientAssertionClientInfoHandler(t *testing.T) {
+	a := &ClientAssertionAuthenticator{clientStore: memClientStore{
+		"c1": &Oauth2Client{ID: "c1", Secret: "secret"},
+		"c2": &Oauth2Client{ID: "c2", TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT},
+	}}
+	request := func(form url.Values, basic ...string) *http.Request {
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(basic) == 2 {
+			r.SetBasicAuth(basic[0], basic[1])
+		}
+		return r
+	}
+
+	id, secret, err := a.ClientInfoHandler(request(url.Values{"client_id": {"c1"}, "client_secret": {"secret"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", id)
+	assert.Equal(t, "secret", secret)
+	id, _, err = a.ClientInfoHandler(request(url.Values{}, "c1", "secret"))
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", id)
+
+	// no secret
+	_, _, err = a.ClientInfoHandler(request(url.Values{"client_id": {"c1"}}))
+	assert.Equal(t, errors.ErrInvalidClient, err)
+	_, _, err = a.ClientInfoHandler(request(url.Values{}, "c1", ""))
+	assert.Equal(t, errors.ErrInvalidClient, err)
+
+	// jwt client without assertion
+	_, _, err = a.ClientInfoHandler(request(url.Values{"client_id": {"c2"}}))
+	assert.Equal(t, errors.ErrInvalidClient, err)
+	_, _, err = a.ClientInfoHandler(request(url.Values{"client_id": {"c2"}, "client_secret": {"x"}}))
+	assert.Equal(t, errors.ErrInvalidClient, err)
+
+	_, _, err = a.ClientInfoHandler(request(url.Values{"client_id": {"c3"}, "client_secret": {"x"}}))
+	assert.Equal(t, errors.ErrInvalidClient, err)
+}
+
+type rotatingJWKSFetcher struct {
+	cached, current *JSONWebKeySet
+	refreshed       int
+}
+
+func (f *rotatingJWKSFetcher) FetchJWKS(uri string) (*JSONWebKeySet, error) {
+	return f.cached, nil
+}
+
+func (f *rotatingJWKSFetcher) RefreshJWKS(uri string) (*JSONWebKeySet, error) {
+	f.refreshed++
+	f.cached = f.current
+	return f.current, nil
+}
+
+func TestClientAssertionKeyRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	rsaJWK := JSONWebKey{Kty: "RSA", Kid: "k2", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())}
+	ecJWK := JSONWebKey{Kty: "EC", Kid: "k2", Crv: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())}
+
+	client := &Oauth2Client{ID: "c1", TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT, JWKSURI: "https://c1/jwks"}
+	fetcher := &rotatingJWKSFetcher{
+		cached:  &JSONWebKeySet{Keys: []JSONWebKey{{Kty: "RSA", Kid: "k1"}}},
+		current: &JSONWebKeySet{Keys: []JSONWebKey{ecJWK, rsaJWK}},
+	}
+	a := &ClientAssertionAuthenticator{fetcher: fetcher}
+
+	// the kid is not in the cached set, refetched once
+	key, err := a.verifyKey(client, &parsedJWT{header: jwtHeader{Alg: "RS256", Kid: "k2"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fetcher.refreshed)
+	// the ec key of the same kid is skipped for an rsa alg
+	assert.Equal(t, &rsaKey.PublicKey, key)
+
+	key, err = a.verifyKey(client, &parsedJWT{header: jwtHeader{Alg: "ES256", Kid: "k2"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fetcher.refreshed)
+	assert.Equal(t, &ecKey.PublicKey, key)
+
+	_, err = a.verifyKey(client, &parsedJWT{header: jwtHeader{Alg: "RS256", Kid: "k3"}})
+	assert.Equal(t, ErrInvalidClientAssertion, err)
+	assert.Equal(t, 2, fetcher.refreshed)
+}
+
+func TestHTTPJWKSFetcher(t *testing.T) {
+	fetched := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		if r.URL.Path == "/large" {
+			w.Write([]byte(`{"keys":[{"kty":"` + strings.Repeat("a", jwksMaxSize) + `"}]}`))
+			return
+		}
+		w.Write([]byte(`{"keys":[{"kty":"RSA","kid":"k1"}]}`))
+	}))
+	defer server.Close()
+
+	f := NewHTTPJWKSFetcher(time.Minute)
+	set, err := f.FetchJWKS(server.URL + "/jwks")
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", set.Keys[0].Kid)
+	_, err = f.FetchJWKS(server.URL + "/jwks")
+	assert.Equal(t, 1, fetched)
+
+	// refreshed once per interval
+	_, err = f.RefreshJWKS(server.URL + "/jwks")
+	assert.Nil(t, err)
+	_, err = f.RefreshJWKS(server.URL + "/jwks")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, fetched)
+
+	_, err = f.FetchJWKS(server.URL + "/large")
+	assert.NotNil(t, err)
+}
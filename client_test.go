@@ -0,0 +1,35 @@
+// authors: wangoo
+// created: 2026-10-19
+// client test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClientStatus(t *testing.T) {
+	c := &Oauth2Client{ID: "c1"}
+	assert.Equal(t, ClientStatusActive, c.GetStatus())
+
+	cli, err := checkClientStatus(c)
+	assert.Nil(t, err)
+	assert.Equal(t, c, cli)
+
+	c.Status = ClientStatusSuspended
+	cli, err = checkClientStatus(c)
+	assert.Nil(t, cli)
+	assert.Equal(t, ErrClientSuspended, err)
+
+	c.Status = ClientStatusDeleted
+	cli, err = checkClientStatus(c)
+	assert.Nil(t, cli)
+	assert.Equal(t, ErrClientDeleted, err)
+}
+
+func TestClientUpdateInvalidStatus(t *testing.T) {
+	cs := &MongoClientStore{}
+	assert.Equal(t, ErrInvalidClientStatus, cs.UpdateStatus("c1", ""))
+	assert.Equal(t, ErrInvalidClientStatus, cs.UpdateStatus("c1", "archived"))
+}
@@ -0,0 +1,92 @@
+// authors: wangoo
+// created: 2026-10-19
+// resolve token lifetimes per client
+
+package o2m
+
+import (
+	"gopkg.in/oauth2.v3"
+	"net/http"
+	"time"
+)
+
+// ClientTokenStore wrap a token store, applying the token lifetimes of the client before creating a token.
+//
+// The oauth2.v3 manager only supports token configuration per grant type,
+// and it builds the token response from the same token info passed to Create,
+// so the lifetimes set here are also the ones returned to the client.
+// An access token generator embedding the expiration, such as the jwt one, runs before Create,
+// so the AccessTokenExpHandler must also be set as the server AccessTokenExpHandler
+// to generate the access token with the client lifetime.
+type ClientTokenStore struct {
+	oauth2.TokenStore
+	clientStore oauth2.ClientStore
+}
+
+// NewClientTokenStore create a token store resolving token lifetimes from the client store,
+// clients not implementing ClientTokenExp keep the manager configuration
+func NewClientTokenStore(ts oauth2.TokenStore, cs oauth2.ClientStore) *ClientTokenStore {
+	if ts == nil || cs == nil {
+		panic("token store and client store cannot be nil")
+	}
+	return &ClientTokenStore{TokenStore: ts, clientStore: cs}
+}
+
+// Create apply the client token lifetimes and store the token information
+func (s *ClientTokenStore) Create(info oauth2.TokenInfo) (err error) {
+	cli, err := s.clientStore.GetByID(info.GetClientID())
+	if err != nil {
+		return
+	}
+	if exp, ok := cli.(ClientTokenExp); ok {
+		applyClientTokenExp(info, exp)
+	}
+	return s.TokenStore.Create(info)
+}
+
+func applyClientTokenExp(info oauth2.TokenInfo, exp ClientTokenExp) {
+	if info.GetCode() != "" && exp.GetCodeExp() > 0 {
+		info.SetCodeExpiresIn(exp.GetCodeExp())
+	}
+	if info.GetAccess() != "" && exp.GetAccessTokenExp() > 0 {
+		info.SetAccessExpiresIn(exp.GetAccessTokenExp())
+	}
+	if info.GetRefresh() != "" && exp.GetRefreshTokenExp() > 0 {
+		info.SetRefreshExpiresIn(exp.GetRefreshTokenExp())
+	}
+}
+
+// client id of a token request, from basic auth, the form or the subject of a client assertion,
+// the client is authenticated by the server
+func tokenRequestClientID(r *http.Request) string {
+	if username, _, ok := r.BasicAuth(); ok {
+		return username
+	}
+	if clientID := r.FormValue("client_id"); clientID != "" {
+		return clientID
+	}
+	if assertion := r.FormValue("client_assertion"); assertion != "" {
+		if jwt, err := parseJWT(assertion); err == nil {
+			return jwt.claims.Subject
+		}
+	}
+	return ""
+}
+
+// AccessTokenExpHandler can be set as the server AccessTokenExpHandler, resolving the access token lifetime
+// of the client before the token is generated, 0 keeps the manager configuration
+func (s *ClientTokenStore) AccessTokenExpHandler(w http.ResponseWriter, r *http.Request) (exp time.Duration, err error) {
+	clientID := tokenRequestClientID(r)
+	if clientID == "" {
+		return
+	}
+	cli, err := s.clientStore.GetByID(clientID)
+	if err != nil {
+		// an unknown client is rejected by the server
+		return 0, nil
+	}
+	if e, ok := cli.(ClientTokenExp); ok {
+		exp = e.GetAccessTokenExp()
+	}
+	return
+}
@@ -0,0 +1,121 @@
+// authors: wangoo
+// created: 2026-10-19
+// client token lifetimes test
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/oauth2.v3"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memClientStore map[string]oauth2.ClientInfo
+
+func (s memClientStore) GetByID(id string) (oauth2.ClientInfo, error) {
+	if cli, ok := s[id]; ok {
+		return cli, nil
+	}
+	return nil, o2x.ErrNotFound
+}
+
+type memTokenStore struct {
+	oauth2.TokenStore
+	created []oauth2.TokenInfo
+}
+
+func (s *memTokenStore) Create(info oauth2.TokenInfo) error {
+	s.created = append(s.created, info)
+	return nil
+}
+
+func TestClientTokenStore(t *testing.T) {
+	cs := memClientStore{
+		"c1": &Oauth2Client{ID: "c1", AccessTokenExp: time.Minute, RefreshTokenExp: time.Hour},
+		"c2": &Oauth2Client{ID: "c2"},
+	}
+	mts := &memTokenStore{}
+	ts := NewClientTokenStore(mts, cs)
+
+	ti := &TokenData{
+		ClientID:         "c1",
+		Access:           "a1",
+		AccessExpiresIn:  2 * time.Hour,
+		Refresh:          "r1",
+		RefreshExpiresIn: 24 * time.Hour,
+	}
+	assert.Nil(t, ts.Create(ti))
+	assert.Equal(t, time.Minute, ti.GetAccessExpiresIn())
+	assert.Equal(t, time.Hour, ti.GetRefreshExpiresIn())
+
+	ti = &TokenData{
+		ClientID:        "c2",
+		Access:          "a2",
+		AccessExpiresIn: 2 * time.Hour,
+	}
+	assert.Nil(t, ts.Create(ti))
+	assert.Equal(t, 2*time.Hour, ti.GetAccessExpiresIn())
+
+	ti = &TokenData{
+		ClientID:      "c1",
+		Code:          "code1",
+		CodeExpiresIn: 10 * time.Minute,
+	}
+	assert.Nil(t, ts.Create(ti))
+	assert.Equal(t, 10*time.Minute, ti.GetCodeExpiresIn())
+
+	assert.Equal(t, o2x.ErrNotFound, ts.Create(&TokenData{ClientID: "c3"}))
+	assert.Equal(t, 3, len(mts.created))
+}
+
+func TestClientTokenStoreAccessTokenExpHandler(t *testing.T) {
+	cs := memClientStore{
+		"c1": &Oauth2Client{ID: "c1", AccessTokenExp: time.Minute},
+		"c2": &Oauth2Client{ID: "c2"},
+	}
+	ts := NewClientTokenStore(&memTokenStore{}, cs)
+	request := func(form url.Values, basic ...string) *http.Request {
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(basic) == 2 {
+			r.SetBasicAuth(basic[0], basic[1])
+		}
+		return r
+	}
+
+	exp, err := ts.AccessTokenExpHandler(nil, request(url.Values{"client_id": {"c1"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, exp)
+	exp, _ = ts.AccessTokenExpHandler(nil, request(url.Values{}, "c1", "secret"))
+	assert.Equal(t, time.Minute, exp)
+	assertion := b64([]byte(`{"alg":"RS256"}`)) + "." + b64([]byte(`{"sub":"c1"}`)) + ".sig"
+	exp, _ = ts.AccessTokenExpHandler(nil, request(url.Values{"client_assertion": {assertion}}))
+	assert.Equal(t, time.Minute, exp)
+
+	// the manager configuration is kept
+	exp, err = ts.AccessTokenExpHandler(nil, request(url.Values{"client_id": {"c2"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), exp)
+	exp, err = ts.AccessTokenExpHandler(nil, request(url.Values{"client_id": {"c3"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), exp)
+
+	// as the manager does with a jwt access generator: the lifetime of the request is set,
+	// the exp claim is generated, then the token is stored
+	now := time.Now()
+	ti := &TokenData{ClientID: "c1", AccessCreateAt: now, AccessExpiresIn: 2 * time.Hour}
+	exp, _ = ts.AccessTokenExpHandler(nil, request(url.Values{"client_id": {"c1"}}))
+	if exp > 0 {
+		ti.AccessExpiresIn = exp
+	}
+	claimExp := ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix()
+	ti.Access = "jwt"
+	assert.Nil(t, ts.Create(ti))
+	assert.Equal(t, claimExp, ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix())
+}
@@ -0,0 +1,131 @@
+// authors: wangoo
+// created: 2026-10-19
+// enforce client registered scopes and grant types
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+	"net/http"
+	"strings"
+)
+
+// ClientValidator check the grant types and scopes requested by a client against the registered ones.
+// A client without registered grant types or scopes is not restricted.
+type ClientValidator struct {
+	clientStore oauth2.ClientStore
+	narrow      bool
+}
+
+// NewClientValidator create a validator, narrow means dropping the scopes not registered
+// instead of rejecting the request, only where the server hook allows to change the scope,
+// i.e. the AuthorizeScopeHandler
+func NewClientValidator(cs oauth2.ClientStore, narrow bool) *ClientValidator {
+	if cs == nil {
+		panic("client store cannot be nil")
+	}
+	return &ClientValidator{clientStore: cs, narrow: narrow}
+}
+
+func (v *ClientValidator) getClient(clientID string) (cli o2x.O2ClientInfo, err error) {
+	info, err := v.clientStore.GetByID(clientID)
+	if err != nil {
+		return
+	}
+	cli, _ = info.(o2x.O2ClientInfo)
+	return
+}
+
+// GrantAllowed whether the grant type is registered for the client
+func GrantAllowed(cli o2x.O2ClientInfo, grant oauth2.GrantType) bool {
+	grants := cli.GetGrantTypes()
+	if len(grants) == 0 {
+		return true
+	}
+	for _, g := range grants {
+		if g == grant {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeAllowed whether all requested scopes are registered for the client
+func ScopeAllowed(cli o2x.O2ClientInfo, scope string) bool {
+	scopes := cli.GetScopes()
+	if len(scopes) == 0 || scope == "" {
+		return true
+	}
+	return o2x.ScopeContains(strings.Join(scopes, ","), scope)
+}
+
+// NarrowScope keep the requested scopes registered for the client,
+// an empty request gets all the registered scopes
+func NarrowScope(cli o2x.O2ClientInfo, scope string) (narrowed string, err error) {
+	scopes := cli.GetScopes()
+	if len(scopes) == 0 {
+		return scope, nil
+	}
+	if scope == "" {
+		return strings.Join(scopes, ","), nil
+	}
+
+	registered := strings.Join(scopes, ",")
+	var allowed []string
+	for _, s := range strings.Split(scope, ",") {
+		if s != "" && o2x.ScopeContains(registered, s) {
+			allowed = append(allowed, s)
+		}
+	}
+	if len(allowed) == 0 {
+		return "", errors.ErrInvalidScope
+	}
+	return strings.Join(allowed, ","), nil
+}
+
+// ClientAuthorizedHandler can be set as the server ClientAuthorizedHandler
+func (v *ClientValidator) ClientAuthorizedHandler(clientID string, grant oauth2.GrantType) (allowed bool, err error) {
+	cli, err := v.getClient(clientID)
+	if err != nil {
+		return
+	}
+	if cli != nil && !GrantAllowed(cli, grant) {
+		return false, errors.ErrUnauthorizedClient
+	}
+	return true, nil
+}
+
+// ClientScopeHandler can be set as the server ClientScopeHandler.
+// The hook cannot change the scope of the token, so a scope not registered is rejected in narrow mode too.
+func (v *ClientValidator) ClientScopeHandler(clientID, scope string) (allowed bool, err error) {
+	cli, err := v.getClient(clientID)
+	if err != nil {
+		return
+	}
+	if cli == nil {
+		return true, nil
+	}
+	if !ScopeAllowed(cli, scope) {
+		return false, errors.ErrInvalidScope
+	}
+	return true, nil
+}
+
+// AuthorizeScopeHandler can be set as the server AuthorizeScopeHandler,
+// returning the scope of the authorization request narrowed or checked against the client
+func (v *ClientValidator) AuthorizeScopeHandler(w http.ResponseWriter, r *http.Request) (scope string, err error) {
+	scope = r.FormValue("scope")
+	cli, err := v.getClient(r.FormValue("client_id"))
+	if err != nil || cli == nil {
+		return
+	}
+	if v.narrow {
+		return NarrowScope(cli, scope)
+	}
+	if !ScopeAllowed(cli, scope) {
+		return "", errors.ErrInvalidScope
+	}
+	return
+}
@@ -0,0 +1,70 @@
+// authors: wangoo
+// created: 2026-10-19
+// client validator test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientValidator(t *testing.T) {
+	cs := memClientStore{
+		"c1": &Oauth2Client{
+			ID:         "c1",
+			Scopes:     []string{"read", "write"},
+			GrantTypes: []oauth2.GrantType{oauth2.AuthorizationCode, oauth2.Refreshing},
+		},
+		"c2": &Oauth2Client{ID: "c2"},
+	}
+
+	v := NewClientValidator(cs, false)
+
+	allowed, err := v.ClientAuthorizedHandler("c1", oauth2.AuthorizationCode)
+	assert.True(t, allowed)
+	assert.Nil(t, err)
+	allowed, err = v.ClientAuthorizedHandler("c1", oauth2.PasswordCredentials)
+	assert.False(t, allowed)
+	assert.Equal(t, errors.ErrUnauthorizedClient, err)
+	allowed, err = v.ClientAuthorizedHandler("c2", oauth2.PasswordCredentials)
+	assert.True(t, allowed)
+	assert.Nil(t, err)
+
+	allowed, err = v.ClientScopeHandler("c1", "read,write")
+	assert.True(t, allowed)
+	assert.Nil(t, err)
+	allowed, err = v.ClientScopeHandler("c1", "read,admin")
+	assert.False(t, allowed)
+	assert.Equal(t, errors.ErrInvalidScope, err)
+	allowed, err = v.ClientScopeHandler("c2", "admin")
+	assert.True(t, allowed)
+
+	v = NewClientValidator(cs, true)
+	allowed, err = v.ClientScopeHandler("c1", "read")
+	assert.True(t, allowed)
+	assert.Nil(t, err)
+	// the token scope cannot be narrowed by the hook
+	allowed, err = v.ClientScopeHandler("c1", "read,admin")
+	assert.False(t, allowed)
+	assert.Equal(t, errors.ErrInvalidScope, err)
+	allowed, err = v.ClientScopeHandler("c1", "admin")
+	assert.False(t, allowed)
+	assert.Equal(t, errors.ErrInvalidScope, err)
+
+	// the authorization request scope is narrowed
+	r := httptest.NewRequest("GET", "/authorize?client_id=c1&scope=read,admin", nil)
+	scope, err := v.AuthorizeScopeHandler(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.Equal(t, "read", scope)
+
+	scope, err = NarrowScope(cs["c1"].(*Oauth2Client), "read,admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "read", scope)
+	scope, err = NarrowScope(cs["c1"].(*Oauth2Client), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "read,write", scope)
+}
@@ -0,0 +1,46 @@
+// authors: wangoo
+// created: 2026-10-19
+// o2m errors
+
+package o2m
+
+import (
+	"errors"
+)
+
+var (
+	ErrClientSuspended     = errors.New("client suspended")
+	ErrClientDeleted       = errors.New("client deleted")
+	ErrInvalidClientStatus = errors.New("invalid client status")
+
+	ErrAccountLocked     = errors.New("account locked")
+	ErrUserSuspended     = errors.New("user suspended")
+	ErrInvalidUserStatus = errors.New("invalid user status")
+
+	ErrDuplicateMobile     = errors.New("mobile duplicated")
+	ErrDuplicateUsername   = errors.New("username duplicated")
+	ErrDuplicateEmail      = errors.New("email duplicated")
+	ErrDuplicateIdentifier = errors.New("identifier duplicated")
+	ErrDuplicateUserID     = errors.New("user id duplicated")
+	ErrDuplicateClientID   = errors.New("client id duplicated")
+	ErrUnknownIdentifier   = errors.New("unknown identifier")
+	ErrIdentityLinked      = errors.New("federated identity already linked")
+
+	ErrVersionConflict  = errors.New("version conflict")
+	ErrInvalidUserField = errors.New("invalid user field")
+	ErrInvalidCursor    = errors.New("invalid cursor")
+
+	ErrTOTPNotEnabled     = errors.New("totp not enabled")
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+	ErrInvalidTOTPCode    = errors.New("invalid totp code")
+	ErrTOTPCodeReused     = errors.New("totp code reused")
+
+	ErrWebAuthnChallenge        = errors.New("invalid webauthn challenge")
+	ErrInvalidWebAuthnResponse  = errors.New("invalid webauthn response")
+	ErrUnsupportedCOSEKey       = errors.New("unsupported cose key")
+	ErrWebAuthnCredentialExists = errors.New("webauthn credential exists")
+	ErrSignCountRegression      = errors.New("webauthn sign count regression")
+
+	ErrInvalidVerificationToken     = errors.New("invalid verification token")
+	ErrVerificationAttemptsExceeded = errors.New("verification attempts exceeded")
+)
@@ -0,0 +1,78 @@
+// authors: wangoo
+// created: 2026-10-19
+// coalescing of concurrent identical loads
+
+package o2m
+
+import (
+	"errors"
+	"github.com/golang/glog"
+	"sync"
+)
+
+var (
+	// returned to the callers waiting for a call which panicked
+	errFlightPanic = errors.New("load panicked")
+
+	// a call returned an unexpected value
+	errFlightResult = errors.New("unexpected load result")
+)
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// flightGroup run only one load of a key at a time, the concurrent callers of the key share its result.
+// The zero value is ready to use.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do run fn, or wait for the running call of the key, shared reports whether the result is of another call
+func (g *flightGroup) Do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	completed := false
+	defer func() {
+		if !completed {
+			call.val, call.err = nil, errFlightPanic
+		}
+		call.wg.Done()
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+	}()
+	call.val, call.err = fn()
+	completed = true
+	return call.val, call.err, false
+}
+
+// Refresh run fn in background unless a call of the key is running, for stale-while-revalidate
+func (g *flightGroup) Refresh(key string, fn func() (interface{}, error)) {
+	g.mu.Lock()
+	_, running := g.calls[key]
+	g.mu.Unlock()
+	if running {
+		return
+	}
+	go func() {
+		if _, err, _ := g.Do(key, fn); err != nil {
+			glog.Warningf("refresh %v error: %v", key, err)
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+// authors: wangoo
+// created: 2026-10-19
+// single flight test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan interface{}, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, _ := g.Do("k", fn)
+			assert.Nil(t, err)
+			results <- v
+		}()
+	}
+	// let the callers join the running call
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for v := range results {
+		assert.Equal(t, "value", v)
+	}
+
+	// a finished call is not shared
+	_, _, shared := g.Do("k", func() (interface{}, error) { return nil, nil })
+	assert.False(t, shared)
+}
+
+func TestFlightGroupRefresh(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	done := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-done
+		return nil, nil
+	}
+
+	g.Refresh("k", fn)
+	time.Sleep(10 * time.Millisecond)
+	// already refreshing
+	g.Refresh("k", fn)
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	go func() {
+		defer func() { recover() }()
+		g.Do("k", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("load failed")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err, shared := g.Do("k", func() (interface{}, error) { return "value", nil })
+		assert.True(t, shared)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	assert.Equal(t, errFlightPanic, <-done)
+
+	// the panicked call is not kept
+	v, err, _ := g.Do("k", func() (interface{}, error) { return "value", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "value", v)
+}
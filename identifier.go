@@ -0,0 +1,398 @@
+// authors: wangoo
+// created: 2026-10-19
+// unique user identifiers: mobile, username, email
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+const (
+	IdentifierMobile   = "mobile"
+	IdentifierUsername = "username"
+	IdentifierEmail    = "email"
+)
+
+// UniqueIdentifier an identifier unique among users.
+// A value is claimed by a document {_id: userId, <Kind>: normalized value}
+// in the collection <collection>_<Kind> having a unique index on <Kind>.
+type UniqueIdentifier struct {
+	Kind string
+
+	// field name in the user document
+	Field string
+
+	// normalize values before claiming and looking up, nil keeps the value
+	Normalize func(string) string
+
+	// returned when the value is claimed by another user, ErrDuplicateIdentifier if nil
+	ErrDuplicate error
+}
+
+func MobileIdentifier() *UniqueIdentifier {
+	return &UniqueIdentifier{
+		Kind:         IdentifierMobile,
+		Field:        "mobile",
+		Normalize:    NormalizeMobile,
+		ErrDuplicate: ErrDuplicateMobile,
+	}
+}
+
+func UsernameIdentifier() *UniqueIdentifier {
+	return &UniqueIdentifier{
+		Kind:         IdentifierUsername,
+		Field:        "username",
+		Normalize:    NormalizeUsername,
+		ErrDuplicate: ErrDuplicateUsername,
+	}
+}
+
+func EmailIdentifier() *UniqueIdentifier {
+	return &UniqueIdentifier{
+		Kind:         IdentifierEmail,
+		Field:        "email",
+		Normalize:    NormalizeEmail,
+		ErrDuplicate: ErrDuplicateEmail,
+	}
+}
+
+// NormalizeMobile remove spaces and separators
+func NormalizeMobile(mobile string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '\t':
+			return -1
+		}
+		return r
+	}, mobile)
+}
+
+// NormalizeUsername usernames are case-insensitive
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// NormalizeEmail emails are case-insensitive
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (idf *UniqueIdentifier) normalize(value string) string {
+	if idf.Normalize == nil {
+		return value
+	}
+	return idf.Normalize(value)
+}
+
+func (idf *UniqueIdentifier) duplicateError() error {
+	if idf.ErrDuplicate == nil {
+		return ErrDuplicateIdentifier
+	}
+	return idf.ErrDuplicate
+}
+
+// AddIdentifiers add unique identifiers, replacing the ones of the same kind,
+// it panics if the kind is the suffix of a side collection of the user store
+func (cfg *MgoUserCfg) AddIdentifiers(identifiers ...*UniqueIdentifier) *MgoUserCfg {
+	for _, idf := range identifiers {
+		if reservedIdentifierKind(idf.Kind) {
+			panic("identifier kind " + idf.Kind + " is reserved")
+		}
+		replaced := false
+		for i, old := range cfg.identifiers {
+			if old.Kind == idf.Kind {
+				cfg.identifiers[i] = idf
+				replaced = true
+			}
+		}
+		if !replaced {
+			cfg.identifiers = append(cfg.identifiers, idf)
+		}
+	}
+	return cfg
+}
+
+func (us *MgoUserStore) identifier(kind string) (*UniqueIdentifier, error) {
+	for _, idf := range us.userCfg.identifiers {
+		if idf.Kind == kind {
+			return idf, nil
+		}
+	}
+	return nil, ErrUnknownIdentifier
+}
+
+func (us *MgoUserStore) identifierCollection(kind string) string {
+	return us.sideCollection(kind)
+}
+
+// a normalized identifier value of a user
+type identifierValue struct {
+	idf   *UniqueIdentifier
+	value string
+}
+
+// marshal the user to read fields not exposed by o2x.User
+func userDocument(u o2x.User) (doc bson.M, err error) {
+	b, err := bson.Marshal(u)
+	if err != nil {
+		return
+	}
+	doc = bson.M{}
+	err = bson.Unmarshal(b, doc)
+	return
+}
+
+func (us *MgoUserStore) identifierValues(u o2x.User) (values []identifierValue, err error) {
+	if len(us.userCfg.identifiers) == 0 {
+		return
+	}
+	doc, err := userDocument(u)
+	if err != nil {
+		return
+	}
+	for _, idf := range us.userCfg.identifiers {
+		s, _ := doc[idf.Field].(string)
+		if s = idf.normalize(s); s != "" {
+			values = append(values, identifierValue{idf: idf, value: s})
+		}
+	}
+	return
+}
+
+/*
+绑定用户唯一标识
+*/
+func (us *MgoUserStore) claimIdentifier(session *mgo.Session, userId string, v identifierValue) (err error) {
+	if userId == "" || v.value == "" {
+		err = o2x.ErrValueRequired
+		return
+	}
+	c := session.DB(us.db).C(us.identifierCollection(v.idf.Kind))
+	err = c.Insert(bson.M{"_id": userId, v.idf.Kind: v.value})
+	if mgo.IsDup(err) {
+		err = us.claimError(session, userId, v)
+	}
+	return
+}
+
+/*
+duplicate key when claiming, either the value is used by another user or the user id already exists
+*/
+func (us *MgoUserStore) claimError(session *mgo.Session, userId string, v identifierValue) error {
+	c := session.DB(us.db).C(us.identifierCollection(v.idf.Kind))
+	claim := bson.M{}
+	err := c.Find(bson.M{v.idf.Kind: v.value}).One(&claim)
+	if err == nil && claim["_id"] != userId {
+		return v.idf.duplicateError()
+	}
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return ErrDuplicateUserID
+}
+
+// claim all values, releasing the claimed ones if any fails
+func (us *MgoUserStore) claimIdentifiers(session *mgo.Session, userId string, values []identifierValue) (claimed []identifierValue, err error) {
+	for _, v := range values {
+		if err = us.claimIdentifier(session, userId, v); err != nil {
+			us.rollbackIdentifiers(session, userId, claimed)
+			return nil, err
+		}
+		claimed = append(claimed, v)
+	}
+	return
+}
+
+/*
+释放保存用户失败时绑定的唯一标识
+*/
+func (us *MgoUserStore) rollbackIdentifiers(session *mgo.Session, userId string, claimed []identifierValue) {
+	for _, v := range claimed {
+		c := session.DB(us.db).C(us.identifierCollection(v.idf.Kind))
+		err := c.Remove(bson.M{"_id": userId, v.idf.Kind: v.value})
+		if err != nil && err != mgo.ErrNotFound {
+			glog.Errorf("rollback user %v %v %v claim error: %v", userId, v.idf.Kind, v.value, err)
+		}
+	}
+}
+
+/*
+解绑用户所有唯一标识, returns the number of released claims
+*/
+func (us *MgoUserStore) releaseIdentifiers(session *mgo.Session, userId string) (n int, err error) {
+	if userId == "" {
+		err = o2x.ErrValueRequired
+		return
+	}
+	for _, idf := range us.userCfg.identifiers {
+		c := session.DB(us.db).C(us.identifierCollection(idf.Kind))
+		mgoErr := c.RemoveId(userId)
+		if mgoErr == nil {
+			n++
+		} else if mgoErr != mgo.ErrNotFound {
+			err = mgoErr
+		}
+	}
+	return
+}
+
+// FindByIdentifier find user by a unique identifier, the value is normalized before looking up.
+// Claims written before normalization hold the raw value and older users may have no claim,
+// on a miss they are looked up by the raw value and the user field, and the claim is backfilled.
+func (us *MgoUserStore) FindByIdentifier(kind, value string) (u o2x.User, err error) {
+	idf, err := us.identifier(kind)
+	if err != nil {
+		return
+	}
+	raw := value
+	value = idf.normalize(value)
+	if value == "" {
+		err = o2x.ErrValueRequired
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.identifierCollection(kind))
+
+	claim := bson.M{}
+	err = c.Find(bson.M{kind: value}).One(&claim)
+	if err == mgo.ErrNotFound {
+		return us.findUnclaimed(session, idf, raw, value)
+	}
+	if err != nil {
+		return
+	}
+	return us.Find(claim["_id"])
+}
+
+// find a user by a raw claim or by the user field, then claim the normalized value
+func (us *MgoUserStore) findUnclaimed(session *mgo.Session, idf *UniqueIdentifier, raw, value string) (u o2x.User, err error) {
+	lc := session.DB(us.db).C(us.identifierCollection(idf.Kind))
+
+	values := []interface{}{value}
+	if raw != value {
+		values = append(values, raw)
+		claim := bson.M{}
+		err = lc.Find(bson.M{idf.Kind: raw}).One(&claim)
+		if err == nil {
+			// normalize the claim in place
+			if err = lc.UpdateId(claim["_id"], bson.M{"$set": bson.M{idf.Kind: value}}); err != nil {
+				glog.Warningf("normalize user %v %v %v claim error: %v", claim["_id"], idf.Kind, value, err)
+			}
+			return us.Find(claim["_id"])
+		}
+		if err != mgo.ErrNotFound {
+			return
+		}
+	}
+
+	var docs []bson.M
+	err = session.DB(us.db).C(us.collection).Find(bson.M{idf.Field: bson.M{"$in": values}}).
+		Select(bson.M{"_id": 1}).Limit(2).All(&docs)
+	if err != nil {
+		return
+	}
+	if len(docs) != 1 {
+		if len(docs) > 1 {
+			glog.Warningf("%v %v used by several users without claim", idf.Kind, value)
+		}
+		return nil, o2x.ErrNotFound
+	}
+	u, err = us.Find(docs[0]["_id"])
+	if err != nil {
+		return
+	}
+	// backfill the claim, not replacing a claim of the user
+	if claimErr := lc.Insert(bson.M{"_id": u.GetID(), idf.Kind: value}); claimErr != nil {
+		glog.Warningf("backfill user %v %v %v claim error: %v", u.GetID(), idf.Kind, value, claimErr)
+	}
+	return
+}
+
+// UpdateIdentifier change a unique identifier of a user, an empty value removes it.
+// The claim of the user is swapped by one atomic update, so the unique index
+// rejects another user racing for the same value.
+func (us *MgoUserStore) UpdateIdentifier(id interface{}, kind, value string) (err error) {
+	idf, err := us.identifier(kind)
+	if err != nil {
+		return
+	}
+	user, err := us.Find(id)
+	if err != nil {
+		return
+	}
+	doc, err := userDocument(user)
+	if err != nil {
+		return
+	}
+	oldValue, _ := doc[idf.Field].(string)
+	if oldValue == value {
+		return
+	}
+	glog.Infof("update user %v %v %v", id, kind, value)
+
+	session := us.session.Clone()
+	defer session.Close()
+	lc := session.DB(us.db).C(us.identifierCollection(kind))
+	c := session.DB(us.db).C(us.collection)
+
+	uid := user.GetID()
+	normalized, oldNormalized := idf.normalize(value), idf.normalize(oldValue)
+	if normalized == "" {
+		err = lc.RemoveId(uid)
+	} else {
+		_, err = lc.UpsertId(uid, bson.M{"$set": bson.M{kind: normalized}})
+	}
+	if err != nil && err != mgo.ErrNotFound {
+		if mgo.IsDup(err) {
+			err = idf.duplicateError()
+		}
+		return
+	}
+
+	// a new value is not verified
+	unverify := bson.M{verifiedField(kind): "", verifiedAtField(kind): ""}
+	update := bson.M{"$set": bson.M{idf.Field: value}, "$unset": unverify}
+	if value == "" {
+		unverify[idf.Field] = ""
+		update = bson.M{"$unset": unverify}
+	}
+	err = c.UpdateId(user.GetUserID(), update)
+	if err != nil {
+		// restore the old claim
+		var rollbackErr error
+		if oldNormalized == "" {
+			rollbackErr = lc.Remove(bson.M{"_id": uid, kind: normalized})
+		} else {
+			_, rollbackErr = lc.UpsertId(uid, bson.M{"$set": bson.M{kind: oldNormalized}})
+		}
+		if rollbackErr != nil && rollbackErr != mgo.ErrNotFound {
+			glog.Errorf("rollback user %v %v %v claim error: %v", uid, kind, oldNormalized, rollbackErr)
+		}
+		if err == mgo.ErrNotFound {
+			err = o2x.ErrNotFound
+		}
+		return
+	}
+
+	us.removeUserCache(id)
+	us.removeUserCache(user.GetUserID())
+	us.publish(id, user.GetUserID())
+	_, err = us.Find(user.GetUserID())
+	return
+}
+
+// UpdateMobile change the mobile of a user, returns ErrDuplicateMobile if used by another user
+func (us *MgoUserStore) UpdateMobile(id interface{}, mobile string) (err error) {
+	if mobile == "" {
+		err = o2x.ErrValueRequired
+		return
+	}
+	return us.UpdateIdentifier(id, IdentifierMobile, mobile)
+}
@@ -0,0 +1,40 @@
+// authors: wangoo
+// created: 2026-10-19
+// unique identifier test
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizeIdentifier(t *testing.T) {
+	assert.Equal(t, "+8613344556677", NormalizeMobile("+86 133-4455 6677"))
+	assert.Equal(t, "13344556677", NormalizeMobile("(133)44556677"))
+	assert.Equal(t, "wangoo", NormalizeUsername(" WangOO "))
+	assert.Equal(t, "wangoo@example.com", NormalizeEmail("WangOO@Example.com "))
+}
+
+func TestIdentifierValues(t *testing.T) {
+	cfg := DefaultMgoUserCfg().AddIdentifiers(EmailIdentifier(), UsernameIdentifier())
+	assert.Equal(t, 3, len(cfg.identifiers))
+	cfg.AddIdentifiers(MobileIdentifier())
+	assert.Equal(t, 3, len(cfg.identifiers))
+
+	us := &MgoUserStore{collection: "user", userCfg: cfg}
+	assert.Equal(t, "user_email", us.identifierCollection(IdentifierEmail))
+
+	assert.Panics(t, func() { cfg.AddIdentifiers(&UniqueIdentifier{Kind: collectionTOTP, Field: "totp"}) })
+	assert.Panics(t, func() { cfg.AddIdentifiers(&UniqueIdentifier{Kind: collectionLoginFailure, Field: "login"}) })
+
+	_, err := us.identifier("nickname")
+	assert.Equal(t, ErrUnknownIdentifier, err)
+
+	values, err := us.identifierValues(&o2x.SimpleUser{UserID: "u1", Mobile: "133 4455 6677"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(values))
+	assert.Equal(t, IdentifierMobile, values[0].idf.Kind)
+	assert.Equal(t, "13344556677", values[0].value)
+}
@@ -0,0 +1,194 @@
+// authors: wangoo
+// created: 2026-10-19
+// cache invalidation across instances
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInvalidationDb         = "oauth2"
+	DefaultInvalidationCollection = "cache_invalidation"
+
+	InvalidateClient = "client"
+	InvalidateUser   = "user"
+
+	// the effective scopes of all the users, published on role and group changes
+	InvalidateScopes = "scopes"
+
+	invalidationCappedBytes = 1 << 20
+	invalidationTailTimeout = 5 * time.Second
+	invalidationRetryDelay  = time.Second
+
+	// mongodb error code when creating an existing collection
+	errCodeNamespaceExists = 48
+)
+
+// one eviction message in the capped collection
+type invalidation struct {
+	ID   bson.ObjectId `bson:"_id"`
+	Kind string        `bson:"kind"`
+	Key  string        `bson:"key"`
+	Node string        `bson:"node"`
+}
+
+// InvalidationBus broadcast cache evictions to all instances through a tailable cursor on a capped collection
+type InvalidationBus struct {
+	session    *mgo.Session
+	db         string
+	collection string
+	node       string
+
+	mu       sync.RWMutex
+	handlers map[string][]func(key string)
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewInvalidationBus create the capped collection if not exists and start tailing it
+func NewInvalidationBus(session *mgo.Session, db, collection string) (bus *InvalidationBus) {
+	if session == nil {
+		panic("session cannot be nil")
+	}
+	bus = &InvalidationBus{
+		session:    session,
+		db:         db,
+		collection: collection,
+		node:       bson.NewObjectId().Hex(),
+		handlers:   make(map[string][]func(key string)),
+		closed:     make(chan struct{}),
+	}
+	if bus.db == "" {
+		bus.db = DefaultInvalidationDb
+	}
+	if bus.collection == "" {
+		bus.collection = DefaultInvalidationCollection
+	}
+
+	err := session.DB(bus.db).C(bus.collection).Create(&mgo.CollectionInfo{
+		Capped:   true,
+		MaxBytes: invalidationCappedBytes,
+	})
+	if qe, ok := err.(*mgo.QueryError); ok && qe.Code == errCodeNamespaceExists {
+		err = nil
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	go bus.tail()
+	return
+}
+
+// Subscribe register a handler evicting keys of the kind
+func (bus *InvalidationBus) Subscribe(kind string, handler func(key string)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers[kind] = append(bus.handlers[kind], handler)
+}
+
+// Publish broadcast the eviction of keys to the other instances
+func (bus *InvalidationBus) Publish(kind string, keys ...string) (err error) {
+	session := bus.session.Clone()
+	defer session.Close()
+
+	c := session.DB(bus.db).C(bus.collection)
+	for _, key := range keys {
+		err = c.Insert(&invalidation{
+			ID:   bson.NewObjectId(),
+			Kind: kind,
+			Key:  key,
+			Node: bus.node,
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Close stop tailing the collection
+func (bus *InvalidationBus) Close() {
+	bus.closeOnce.Do(func() {
+		close(bus.closed)
+	})
+}
+
+func (bus *InvalidationBus) isClosed() bool {
+	select {
+	case <-bus.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (bus *InvalidationBus) dispatch(msg *invalidation) {
+	// the publisher already evicted its own cache
+	if msg.Node == bus.node {
+		return
+	}
+	bus.mu.RLock()
+	handlers := bus.handlers[msg.Kind]
+	bus.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(msg.Key)
+	}
+}
+
+// the id of the last message in the collection, messages before it are skipped
+func (bus *InvalidationBus) lastID(c *mgo.Collection) (id bson.ObjectId) {
+	msg := &invalidation{}
+	if err := c.Find(nil).Sort("-$natural").One(msg); err == nil {
+		id = msg.ID
+	}
+	return
+}
+
+func (bus *InvalidationBus) tail() {
+	session := bus.session.Copy()
+	defer session.Close()
+	c := session.DB(bus.db).C(bus.collection)
+
+	// ids from several instances are not ordered, so messages are resumed
+	// by the insertion order of the capped collection, skipping until the last seen one
+	last := bus.lastID(c)
+	for !bus.isClosed() {
+		skip := last != ""
+		if skip {
+			if n, err := c.FindId(last).Count(); err != nil || n == 0 {
+				// the last seen message has been overwritten, evicting again is harmless
+				skip = false
+			}
+		}
+
+		msg := &invalidation{}
+		iter := c.Find(nil).Sort("$natural").Tail(invalidationTailTimeout)
+		for !bus.isClosed() {
+			for iter.Next(msg) {
+				if skip {
+					skip = msg.ID != last
+					continue
+				}
+				last = msg.ID
+				bus.dispatch(msg)
+			}
+			if iter.Err() != nil || !iter.Timeout() {
+				break
+			}
+		}
+		if err := iter.Close(); err != nil {
+			glog.Warningf("tail cache invalidation error: %v", err)
+		}
+
+		time.Sleep(invalidationRetryDelay)
+		session.Refresh()
+	}
+}
@@ -0,0 +1,34 @@
+// authors: wangoo
+// created: 2026-10-19
+// cache invalidation test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestInvalidationDispatch(t *testing.T) {
+	bus := &InvalidationBus{
+		node:     "n1",
+		handlers: make(map[string][]func(key string)),
+		closed:   make(chan struct{}),
+	}
+
+	var evicted []string
+	bus.Subscribe(InvalidateClient, func(key string) {
+		evicted = append(evicted, key)
+	})
+
+	bus.dispatch(&invalidation{ID: bson.NewObjectId(), Kind: InvalidateClient, Key: "c1", Node: "n2"})
+	bus.dispatch(&invalidation{ID: bson.NewObjectId(), Kind: InvalidateUser, Key: "u1", Node: "n2"})
+	bus.dispatch(&invalidation{ID: bson.NewObjectId(), Kind: InvalidateClient, Key: "c2", Node: "n1"})
+	assert.Equal(t, []string{"c1"}, evicted)
+
+	assert.False(t, bus.isClosed())
+	bus.Close()
+	bus.Close()
+	assert.True(t, bus.isClosed())
+}
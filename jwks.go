@@ -0,0 +1,170 @@
+// authors: wangoo
+// created: 2026-10-19
+// json web key set of clients
+
+package o2m
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultJWKSCacheExp = 10 * time.Minute
+	jwksFetchTimeout    = 10 * time.Second
+
+	// max size of a fetched key set
+	jwksMaxSize = 1 << 20
+
+	// min interval between refreshes of a key set on an unknown kid
+	jwksMinRefresh = time.Minute
+)
+
+// JSONWebKey a public RSA or EC key of a client
+type JSONWebKey struct {
+	Kty string `bson:"kty" json:"kty"`
+	Kid string `bson:"kid,omitempty" json:"kid,omitempty"`
+	Use string `bson:"use,omitempty" json:"use,omitempty"`
+	Alg string `bson:"alg,omitempty" json:"alg,omitempty"`
+
+	// RSA
+	N string `bson:"n,omitempty" json:"n,omitempty"`
+	E string `bson:"e,omitempty" json:"e,omitempty"`
+
+	// EC
+	Crv string `bson:"crv,omitempty" json:"crv,omitempty"`
+	X   string `bson:"x,omitempty" json:"x,omitempty"`
+	Y   string `bson:"y,omitempty" json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `bson:"keys" json:"keys"`
+}
+
+// PublicKey decode the key into *rsa.PublicKey or *ecdsa.PublicKey
+func (k *JSONWebKey) PublicKey() (pub crypto.PublicKey, err error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %v", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing key parameter")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSFetcher fetch the key set published at jwks_uri
+type JWKSFetcher interface {
+	FetchJWKS(uri string) (*JSONWebKeySet, error)
+}
+
+// JWKSRefresher a fetcher which can bypass its cache,
+// used when no key of the cached set matches an assertion after a key rotation
+type JWKSRefresher interface {
+	RefreshJWKS(uri string) (*JSONWebKeySet, error)
+}
+
+// HTTPJWKSFetcher fetch key sets over http and cache them
+type HTTPJWKSFetcher struct {
+	client *http.Client
+	cache  *cache.Cache
+}
+
+// NewHTTPJWKSFetcher create a fetcher caching key sets for the given duration
+func NewHTTPJWKSFetcher(exp time.Duration) *HTTPJWKSFetcher {
+	if exp <= 0 {
+		exp = DefaultJWKSCacheExp
+	}
+	return &HTTPJWKSFetcher{
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		cache:  cache.New(exp, 2*exp),
+	}
+}
+
+func (f *HTTPJWKSFetcher) FetchJWKS(uri string) (set *JSONWebKeySet, err error) {
+	if c, found := f.cache.Get(uri); found {
+		return c.(*JSONWebKeySet), nil
+	}
+	return f.fetch(uri)
+}
+
+// RefreshJWKS fetch the key set again, at most once per jwksMinRefresh for an uri
+func (f *HTTPJWKSFetcher) RefreshJWKS(uri string) (set *JSONWebKeySet, err error) {
+	if _, found := f.cache.Get("refresh:" + uri); found {
+		return f.FetchJWKS(uri)
+	}
+	f.cache.Set("refresh:"+uri, true, jwksMinRefresh)
+	return f.fetch(uri)
+}
+
+func (f *HTTPJWKSFetcher) fetch(uri string) (set *JSONWebKeySet, err error) {
+	resp, err := f.client.Get(uri)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks %v: status %v", uri, resp.StatusCode)
+	}
+
+	set = &JSONWebKeySet{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, jwksMaxSize)).Decode(set); err != nil {
+		return nil, err
+	}
+	f.cache.Set(uri, set, cache.DefaultExpiration)
+	return
+}
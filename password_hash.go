@@ -0,0 +1,389 @@
+// authors: wangoo
+// created: 2026-10-19
+// pluggable password hashing in PHC string format
+
+package o2m
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"strconv"
+	"strings"
+)
+
+const (
+	hashIDArgon2id     = "argon2id"
+	hashIDScrypt       = "scrypt"
+	hashIDPBKDF2SHA256 = "pbkdf2-sha256"
+
+	defaultSaltLen = 16
+	defaultKeyLen  = 32
+)
+
+var (
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+	ErrInvalidPasswordHash = errors.New("invalid password hash")
+)
+
+var phcEncoding = base64.RawStdEncoding
+
+// PasswordHasher hash passwords into self-describing strings,
+// the PHC string format or the modular crypt format of bcrypt
+type PasswordHasher interface {
+	// Hash the raw password with a random salt
+	Hash(raw string) (string, error)
+
+	// Verify the raw password against a hash of this hasher
+	Verify(raw, encoded string) (bool, error)
+
+	// NeedsRehash whether the hash uses another algorithm or other parameters than this hasher
+	NeedsRehash(encoded string) bool
+}
+
+// VerifyPasswordHash verify the raw password against a hash of any supported algorithm,
+// the parameters are read from the hash
+func VerifyPasswordHash(raw, encoded string) (bool, error) {
+	switch {
+	case isBcryptHash(encoded):
+		return (&BcryptHasher{}).Verify(raw, encoded)
+	case strings.HasPrefix(encoded, "$"+hashIDArgon2id+"$"):
+		return (&Argon2idHasher{}).Verify(raw, encoded)
+	case strings.HasPrefix(encoded, "$"+hashIDScrypt+"$"):
+		return (&ScryptHasher{}).Verify(raw, encoded)
+	case strings.HasPrefix(encoded, "$"+hashIDPBKDF2SHA256+"$"):
+		return (&PBKDF2Hasher{}).Verify(raw, encoded)
+	}
+	return false, ErrUnknownPasswordHash
+}
+
+func randomSalt(n int) (salt []byte, err error) {
+	salt = make([]byte, n)
+	_, err = rand.Read(salt)
+	return
+}
+
+// phcHash $id[$v=version][$k=v,...]$salt$hash
+type phcHash struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func (h *phcHash) String() string {
+	s := "$" + h.id
+	if h.version != "" {
+		s += "$v=" + h.version
+	}
+	if len(h.params) > 0 {
+		// parameters in a fixed order so that the same configuration gives the same prefix
+		var kv []string
+		for _, k := range []string{"m", "t", "p", "ln", "r", "i", "l"} {
+			if v, ok := h.params[k]; ok {
+				kv = append(kv, k+"="+v)
+			}
+		}
+		s += "$" + strings.Join(kv, ",")
+	}
+	return s + "$" + phcEncoding.EncodeToString(h.salt) + "$" + phcEncoding.EncodeToString(h.hash)
+}
+
+func parsePHC(id, encoded string) (h *phcHash, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 4 || parts[0] != "" || parts[1] != id {
+		return nil, ErrUnknownPasswordHash
+	}
+	h = &phcHash{id: id, params: make(map[string]string)}
+	fields := parts[2 : len(parts)-2]
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		h.version = fields[0][2:]
+		fields = fields[1:]
+	}
+	if len(fields) > 1 {
+		return nil, ErrInvalidPasswordHash
+	}
+	if len(fields) == 1 {
+		for _, kv := range strings.Split(fields[0], ",") {
+			idx := strings.Index(kv, "=")
+			if idx <= 0 {
+				return nil, ErrInvalidPasswordHash
+			}
+			h.params[kv[:idx]] = kv[idx+1:]
+		}
+	}
+	if h.salt, err = phcEncoding.DecodeString(parts[len(parts)-2]); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	if h.hash, err = phcEncoding.DecodeString(parts[len(parts)-1]); err != nil || len(h.hash) == 0 {
+		return nil, ErrInvalidPasswordHash
+	}
+	return
+}
+
+func (h *phcHash) intParam(name string) (int, error) {
+	v, err := strconv.Atoi(h.params[name])
+	if err != nil || v <= 0 {
+		return 0, ErrInvalidPasswordHash
+	}
+	return v, nil
+}
+
+//------------------------------- bcrypt
+
+// BcryptHasher bcrypt in its own modular crypt format, passwords longer than 72 bytes are truncated by bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *BcryptHasher) Hash(raw string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(raw), b.Cost)
+	return string(h), err
+}
+
+func (b *BcryptHasher) Verify(raw, encoded string) (bool, error) {
+	if !isBcryptHash(encoded) {
+		return false, ErrUnknownPasswordHash
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(raw))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+//------------------------------- argon2id
+
+// Argon2idHasher argon2id, Memory in KiB
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// NewArgon2idHasher argon2id with the parameters recommended by RFC 9106 for memory constrained environments
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+		SaltLen: defaultSaltLen,
+		KeyLen:  defaultKeyLen,
+	}
+}
+
+func (a *Argon2idHasher) Hash(raw string) (string, error) {
+	salt, err := randomSalt(int(a.SaltLen))
+	if err != nil {
+		return "", err
+	}
+	h := &phcHash{
+		id:      hashIDArgon2id,
+		version: strconv.Itoa(argon2.Version),
+		params: map[string]string{
+			"m": strconv.Itoa(int(a.Memory)),
+			"t": strconv.Itoa(int(a.Time)),
+			"p": strconv.Itoa(int(a.Threads)),
+		},
+		salt: salt,
+		hash: argon2.IDKey([]byte(raw), salt, a.Time, a.Memory, a.Threads, a.KeyLen),
+	}
+	return h.String(), nil
+}
+
+func (a *Argon2idHasher) Verify(raw, encoded string) (bool, error) {
+	h, err := parsePHC(hashIDArgon2id, encoded)
+	if err != nil {
+		return false, err
+	}
+	if h.version != strconv.Itoa(argon2.Version) {
+		return false, ErrInvalidPasswordHash
+	}
+	m, err := h.intParam("m")
+	if err != nil {
+		return false, err
+	}
+	t, err := h.intParam("t")
+	if err != nil {
+		return false, err
+	}
+	p, err := h.intParam("p")
+	if err != nil || p > 255 {
+		return false, ErrInvalidPasswordHash
+	}
+	key := argon2.IDKey([]byte(raw), h.salt, uint32(t), uint32(m), uint8(p), uint32(len(h.hash)))
+	return subtle.ConstantTimeCompare(key, h.hash) == 1, nil
+}
+
+func (a *Argon2idHasher) NeedsRehash(encoded string) bool {
+	h, err := parsePHC(hashIDArgon2id, encoded)
+	if err != nil {
+		return true
+	}
+	return h.version != strconv.Itoa(argon2.Version) ||
+		h.params["m"] != strconv.Itoa(int(a.Memory)) ||
+		h.params["t"] != strconv.Itoa(int(a.Time)) ||
+		h.params["p"] != strconv.Itoa(int(a.Threads)) ||
+		len(h.salt) != int(a.SaltLen) ||
+		len(h.hash) != int(a.KeyLen)
+}
+
+//------------------------------- scrypt
+
+// ScryptHasher scrypt, N = 2^LogN
+type ScryptHasher struct {
+	LogN    int
+	R       int
+	P       int
+	SaltLen int
+	KeyLen  int
+}
+
+func NewScryptHasher() *ScryptHasher {
+	return &ScryptHasher{
+		LogN:    15,
+		R:       8,
+		P:       1,
+		SaltLen: defaultSaltLen,
+		KeyLen:  defaultKeyLen,
+	}
+}
+
+func (s *ScryptHasher) Hash(raw string) (string, error) {
+	salt, err := randomSalt(s.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(raw), salt, 1<<uint(s.LogN), s.R, s.P, s.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	h := &phcHash{
+		id: hashIDScrypt,
+		params: map[string]string{
+			"ln": strconv.Itoa(s.LogN),
+			"r":  strconv.Itoa(s.R),
+			"p":  strconv.Itoa(s.P),
+		},
+		salt: salt,
+		hash: key,
+	}
+	return h.String(), nil
+}
+
+func (s *ScryptHasher) Verify(raw, encoded string) (bool, error) {
+	h, err := parsePHC(hashIDScrypt, encoded)
+	if err != nil {
+		return false, err
+	}
+	ln, err := h.intParam("ln")
+	if err != nil || ln > 30 {
+		return false, ErrInvalidPasswordHash
+	}
+	r, err := h.intParam("r")
+	if err != nil {
+		return false, err
+	}
+	p, err := h.intParam("p")
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(raw), h.salt, 1<<uint(ln), r, p, len(h.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, h.hash) == 1, nil
+}
+
+func (s *ScryptHasher) NeedsRehash(encoded string) bool {
+	h, err := parsePHC(hashIDScrypt, encoded)
+	if err != nil {
+		return true
+	}
+	return h.params["ln"] != strconv.Itoa(s.LogN) ||
+		h.params["r"] != strconv.Itoa(s.R) ||
+		h.params["p"] != strconv.Itoa(s.P) ||
+		len(h.salt) != s.SaltLen ||
+		len(h.hash) != s.KeyLen
+}
+
+//------------------------------- pbkdf2
+
+// PBKDF2Hasher pbkdf2 with hmac-sha256
+type PBKDF2Hasher struct {
+	Iterations int
+	SaltLen    int
+	KeyLen     int
+}
+
+func NewPBKDF2Hasher() *PBKDF2Hasher {
+	return &PBKDF2Hasher{
+		Iterations: 310000,
+		SaltLen:    defaultSaltLen,
+		KeyLen:     defaultKeyLen,
+	}
+}
+
+func (p *PBKDF2Hasher) Hash(raw string) (string, error) {
+	salt, err := randomSalt(p.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	h := &phcHash{
+		id: hashIDPBKDF2SHA256,
+		params: map[string]string{
+			"i": strconv.Itoa(p.Iterations),
+		},
+		salt: salt,
+		hash: pbkdf2.Key([]byte(raw), salt, p.Iterations, p.KeyLen, sha256.New),
+	}
+	return h.String(), nil
+}
+
+func (p *PBKDF2Hasher) Verify(raw, encoded string) (bool, error) {
+	h, err := parsePHC(hashIDPBKDF2SHA256, encoded)
+	if err != nil {
+		return false, err
+	}
+	i, err := h.intParam("i")
+	if err != nil {
+		return false, err
+	}
+	key := pbkdf2.Key([]byte(raw), h.salt, i, len(h.hash), sha256.New)
+	return subtle.ConstantTimeCompare(key, h.hash) == 1, nil
+}
+
+func (p *PBKDF2Hasher) NeedsRehash(encoded string) bool {
+	h, err := parsePHC(hashIDPBKDF2SHA256, encoded)
+	if err != nil {
+		return true
+	}
+	return h.params["i"] != strconv.Itoa(p.Iterations) ||
+		len(h.salt) != p.SaltLen ||
+		len(h.hash) != p.KeyLen
+}
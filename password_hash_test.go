@@ -0,0 +1,52 @@
+// authors: wangoo
+// created: 2026-10-19
+// password hash test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"$2a$":            &BcryptHasher{Cost: 4},
+		"$argon2id$v=19$": &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, SaltLen: 16, KeyLen: 32},
+		"$scrypt$":        &ScryptHasher{LogN: 4, R: 8, P: 1, SaltLen: 16, KeyLen: 32},
+		"$pbkdf2-sha256$": &PBKDF2Hasher{Iterations: 10, SaltLen: 16, KeyLen: 32},
+	}
+
+	for prefix, h := range hashers {
+		encoded, err := h.Hash("123456")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(encoded, prefix), encoded)
+
+		ok, err := h.Verify("123456", encoded)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = h.Verify("654321", encoded)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		ok, err = VerifyPasswordHash("123456", encoded)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		assert.False(t, h.NeedsRehash(encoded))
+		for otherPrefix, other := range hashers {
+			if otherPrefix != prefix {
+				assert.True(t, other.NeedsRehash(encoded))
+			}
+		}
+	}
+
+	encoded, _ := (&PBKDF2Hasher{Iterations: 10, SaltLen: 16, KeyLen: 32}).Hash("123456")
+	assert.True(t, (&PBKDF2Hasher{Iterations: 20, SaltLen: 16, KeyLen: 32}).NeedsRehash(encoded))
+
+	_, err := VerifyPasswordHash("123456", "plain")
+	assert.Equal(t, ErrUnknownPasswordHash, err)
+	_, err = VerifyPasswordHash("123456", "$scrypt$ln=x$c2FsdA$aGFzaA")
+	assert.Equal(t, ErrInvalidPasswordHash, err)
+}
@@ -0,0 +1,214 @@
+// authors: wangoo
+// created: 2026-10-19
+// password policy and password history
+
+package o2m
+
+import (
+	"bufio"
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// PasswordViolation code of a broken password rule, to be displayed by the UI
+type PasswordViolation string
+
+const (
+	PasswordTooShort      PasswordViolation = "too_short"
+	PasswordMissingUpper  PasswordViolation = "missing_upper"
+	PasswordMissingLower  PasswordViolation = "missing_lower"
+	PasswordMissingDigit  PasswordViolation = "missing_digit"
+	PasswordMissingSymbol PasswordViolation = "missing_symbol"
+	PasswordCommon        PasswordViolation = "common"
+	PasswordReused        PasswordViolation = "reused"
+
+	DefaultPasswordMinLength = 8
+)
+
+// PasswordPolicyError the violations of a password rejected by the policy
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	codes := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		codes[i] = string(v)
+	}
+	return "password policy violations: " + strings.Join(codes, ",")
+}
+
+// PasswordPolicy rules checked by UpdatePwd
+type PasswordPolicy struct {
+	MinLength int
+
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// lower case common or breached passwords
+	CommonPasswords map[string]bool
+
+	// number of last passwords which cannot be reused, 0 to keep no history
+	History int
+
+	// hasher of the history, PBKDF2 by default
+	HistoryHasher PasswordHasher
+}
+
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:     DefaultPasswordMinLength,
+		RequireLower:  true,
+		RequireDigit:  true,
+		HistoryHasher: NewPBKDF2Hasher(),
+	}
+}
+
+// LoadCommonPasswords add the passwords of a file, one per line, to the common passwords
+func (p *PasswordPolicy) LoadCommonPasswords(path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if p.CommonPasswords == nil {
+		p.CommonPasswords = make(map[string]bool)
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			p.CommonPasswords[strings.ToLower(line)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Check the rules not depending on the history
+func (p *PasswordPolicy) Check(raw string) (violations []PasswordViolation) {
+	var length int
+	var upper, lower, digit, symbol bool
+	for _, r := range raw {
+		length++
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if length < p.MinLength {
+		violations = append(violations, PasswordTooShort)
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, PasswordMissingUpper)
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, PasswordMissingLower)
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, PasswordMissingDigit)
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, PasswordMissingSymbol)
+	}
+	if p.CommonPasswords[strings.ToLower(raw)] {
+		violations = append(violations, PasswordCommon)
+	}
+	return
+}
+
+func (p *PasswordPolicy) historyHasher() PasswordHasher {
+	if p.HistoryHasher == nil {
+		return NewPBKDF2Hasher()
+	}
+	return p.HistoryHasher
+}
+
+// SetPasswordPolicy check the passwords of UpdatePwd against the policy,
+// the last passwords are kept as hashes in the history field of the user
+func (us *MgoUserStore) SetPasswordPolicy(policy *PasswordPolicy) {
+	us.policy = policy
+}
+
+// checkPassword check the new password of the user, returns a *PasswordPolicyError on violations
+func (us *MgoUserStore) checkPassword(user o2x.User, raw string) error {
+	if us.policy == nil {
+		return nil
+	}
+	violations := us.policy.Check(raw)
+	if us.policy.History > 0 {
+		reused, err := us.passwordReused(user, raw)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, PasswordReused)
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// whether the password is the current one or one of the history
+func (us *MgoUserStore) passwordReused(user o2x.User, raw string) (reused bool, err error) {
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	cfg := us.userCfg
+	doc := bson.M{}
+	err = c.FindId(user.GetUserID()).Select(bson.M{cfg.hashName: 1, cfg.historyName: 1}).One(&doc)
+	if err != nil {
+		return
+	}
+
+	if encoded, _ := doc[cfg.hashName].(string); encoded != "" {
+		if ok, _ := VerifyPasswordHash(raw, encoded); ok {
+			return true, nil
+		}
+	} else if len(user.GetPassword()) > 0 && user.Match(raw) {
+		return true, nil
+	}
+
+	history, _ := doc[cfg.historyName].([]interface{})
+	for i := len(history) - 1; i >= 0 && i >= len(history)-us.policy.History; i-- {
+		encoded, _ := history[i].(string)
+		if ok, err := VerifyPasswordHash(raw, encoded); err != nil {
+			glog.Warningf("user %v password history error: %v", user.GetUserID(), err)
+		} else if ok {
+			return true, nil
+		}
+	}
+	return
+}
+
+// the update pushing the new password to the history, keeping the last entries of the policy
+func (us *MgoUserStore) historyUpdate(raw string) (push bson.M, err error) {
+	if us.policy == nil || us.policy.History <= 0 {
+		return
+	}
+	encoded, err := us.policy.historyHasher().Hash(raw)
+	if err != nil {
+		return
+	}
+	push = bson.M{us.userCfg.historyName: bson.M{
+		"$each":  []string{encoded},
+		"$slice": -us.policy.History,
+	}}
+	return
+}
@@ -0,0 +1,49 @@
+// authors: wangoo
+// created: 2026-10-19
+// password policy test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	p := DefaultPasswordPolicy()
+	assert.Empty(t, p.Check("secret123"))
+	assert.Equal(t, []PasswordViolation{PasswordTooShort}, p.Check("abc123"))
+	assert.Equal(t, []PasswordViolation{PasswordMissingDigit}, p.Check("password"))
+	assert.Equal(t, []PasswordViolation{PasswordTooShort, PasswordMissingLower}, p.Check("1234"))
+
+	p.RequireUpper = true
+	p.RequireSymbol = true
+	assert.Equal(t, []PasswordViolation{PasswordMissingUpper, PasswordMissingSymbol}, p.Check("secret123"))
+	assert.Empty(t, p.Check("Secret 123"))
+	// length in characters
+	p.MinLength = 4
+	assert.Empty(t, p.Check("密码Aa1!"))
+}
+
+func TestLoadCommonPasswords(t *testing.T) {
+	f, err := ioutil.TempFile("", "common")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("# top passwords\nPassword1\n\nqwerty123\n")
+	f.Close()
+
+	p := DefaultPasswordPolicy()
+	assert.Nil(t, p.LoadCommonPasswords(f.Name()))
+	assert.Len(t, p.CommonPasswords, 2)
+	assert.Equal(t, []PasswordViolation{PasswordCommon}, p.Check("password1"))
+	assert.Empty(t, p.Check("qwerty1234"))
+
+	assert.NotNil(t, p.LoadCommonPasswords(f.Name()+".missing"))
+}
+
+func TestPasswordPolicyError(t *testing.T) {
+	var err error = &PasswordPolicyError{Violations: []PasswordViolation{PasswordTooShort, PasswordReused}}
+	assert.Equal(t, "password policy violations: too_short,reused", err.Error())
+}
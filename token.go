@@ -17,6 +17,9 @@ type MgoTokenStore struct {
 	db         string
 	collection string
 	session    *mgo.Session
+
+	// coalesce concurrent GetByAccess of a token
+	flight flightGroup
 }
 
 // NewTokenStore create a token store instance based on mongodb
@@ -147,6 +150,46 @@ func (ts *MgoTokenStore) RemoveByAccountNoClient(userID string) (err error) {
 	return
 }
 
+// RemoveByUser remove all token info of a user, in both the string and the object id forms,
+// returns the number of removed tokens
+func (ts *MgoTokenStore) RemoveByUser(userID string) (n int, err error) {
+	ts.H(ts.collection, func(c *mgo.Collection) {
+		var info *mgo.ChangeInfo
+		info, err = c.RemoveAll(bson.M{"UserID": userID})
+		if err != nil {
+			return
+		}
+		n = info.Removed
+		if bson.IsObjectIdHex(userID) {
+			info, err = c.RemoveAll(bson.M{"UserID": bson.ObjectIdHex(userID)})
+			if err == nil {
+				n += info.Removed
+			}
+		}
+	})
+	return
+}
+
+// FindByUser find all token info of a user, in both the string and the object id forms
+func (ts *MgoTokenStore) FindByUser(userID string) (tokens []*TokenData, err error) {
+	ts.H(ts.collection, func(c *mgo.Collection) {
+		ids := []interface{}{userID}
+		if bson.IsObjectIdHex(userID) {
+			ids = append(ids, bson.ObjectIdHex(userID))
+		}
+		err = c.Find(bson.M{"UserID": bson.M{"$in": ids}}).All(&tokens)
+	})
+	return
+}
+
+// RemoveByClient remove all token info of a client
+func (ts *MgoTokenStore) RemoveByClient(clientID string) (err error) {
+	ts.H(ts.collection, func(c *mgo.Collection) {
+		_, err = c.RemoveAll(bson.M{"ClientId": clientID})
+	})
+	return
+}
+
 // GetByField use field value for token information data
 func (ts *MgoTokenStore) GetByBson(m bson.M) (ti oauth2.TokenInfo, err error) {
 	ts.H(ts.collection, func(c *mgo.Collection) {
@@ -177,9 +220,22 @@ func (ts *MgoTokenStore) GetByCode(code string) (ti oauth2.TokenInfo, err error)
 	return
 }
 
-// GetByAccess use the access token for token information data
+// GetByAccess use the access token for token information data,
+// concurrent lookups of the same token share one query
 func (ts *MgoTokenStore) GetByAccess(access string) (ti oauth2.TokenInfo, err error) {
-	ti, err = ts.GetByField("_id", access)
+	v, err, _ := ts.flight.Do(access, func() (interface{}, error) {
+		return ts.GetByField("_id", access)
+	})
+	if err != nil {
+		return
+	}
+	data, ok := v.(*TokenData)
+	if !ok {
+		return nil, errFlightResult
+	}
+	// each caller gets its own copy
+	token := *data
+	ti = &token
 	return
 }
 
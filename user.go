@@ -7,7 +7,6 @@ package o2m
 import (
 	"fmt"
 	"github.com/golang/glog"
-	"github.com/patrickmn/go-cache"
 	"github.com/soundbus-technologies/o2x"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -15,32 +14,31 @@ import (
 	"time"
 )
 
-var (
-	userCache *cache.Cache
-)
-
-func init() {
-	// Create a cache with a default expiration time of 5 minutes, and which
-	// purges expired items every 10 minutes
-	userCache = cache.New(5*time.Minute, 10*time.Minute)
-}
-
-func addUserCache(user o2x.User) {
+func (us *MgoUserStore) addUserCache(user o2x.User) {
 	if user.GetUserID() != nil {
-		userCache.Add(fmt.Sprint(user.GetUserID()), user, cache.DefaultExpiration)
+		us.cache.Set(fmt.Sprint(user.GetUserID()), user)
 	}
 }
 
-func getUserCache(id interface{}) (user o2x.User) {
-	if c, found := userCache.Get(fmt.Sprint(id)); found {
+// getUserCache returns found with a nil user if cached as not found, stale if to be refreshed
+func (us *MgoUserStore) getUserCache(id interface{}) (user o2x.User, found, stale bool) {
+	c, found, stale := cacheLookup(us.cache, fmt.Sprint(id))
+	if found && c != nil {
 		user = c.(o2x.User)
-		return
 	}
 	return
 }
 
-func removeUserCache(id interface{}) {
-	userCache.Delete(fmt.Sprint(id))
+// remove the user and its effective scopes
+func (us *MgoUserStore) removeUserCache(id interface{}) {
+	us.removeUserCacheKey(fmt.Sprint(id))
+}
+
+func (us *MgoUserStore) removeUserCacheKey(key string) {
+	us.cacheGen.invalidate(func() {
+		us.cache.Delete(key)
+		us.scopeCache.Delete(key)
+	})
 }
 
 type MgoUserCfg struct {
@@ -51,110 +49,186 @@ type MgoUserCfg struct {
 
 	// salt field name
 	saltName string
+
+	// field name of the password hash in PHC string format, used when a PasswordHasher is set
+	hashName string
+
+	// identifiers unique among users
+	identifiers []*UniqueIdentifier
+
+	// version field name, increased by each Update
+	versionName string
+
+	// creation time field name, set by Save if the user type does not set it
+	createdName string
+
+	// status field name, the status time and the soft deletion time field names
+	statusName        string
+	statusUpdatedName string
+	deletedName       string
+
+	// password history field name, used when a PasswordPolicy keeps a history
+	historyName string
+
+	// mobile field name, also the field of the mobile identifier
+	mobileName string
+
+	// scopes field name, the scope map by client id
+	scopesName string
 }
 
-// used to control the unique mobile for one user if exists
+// used to control the unique mobile for one user if exists,
+// the claim document of the mobile identifier
 type MgoUserMobile struct {
 	Id     string `bson:"_id" json:"_id"`       //用户id
 	Mobile string `bson:"mobile" json:"mobile"` //手机号码
 }
 
 type MgoUserStore struct {
-	session          *mgo.Session
-	db               string
-	collection       string
-	mobileCollection string
-	userCfg          *MgoUserCfg
+	session    *mgo.Session
+	db         string
+	collection string
+	userCfg    *MgoUserCfg
+	bus        *InvalidationBus
+	hasher     PasswordHasher
+	policy     *PasswordPolicy
+
+	lockout           *LockoutPolicy
+	failureCollection string
+
+	cache      Cache
+	scopeCache Cache
+	cacheGen   cacheGeneration
+	flight     flightGroup
 }
 
 func DefaultMgoUserCfg() *MgoUserCfg {
 	return &MgoUserCfg{
-		userType:     o2x.SimpleUserPtrType,
-		passwordName: "password",
-		saltName:     "salt",
+		userType:          o2x.SimpleUserPtrType,
+		passwordName:      "password",
+		saltName:          "salt",
+		hashName:          "password_hash",
+		identifiers:       []*UniqueIdentifier{MobileIdentifier()},
+		versionName:       "version",
+		createdName:       "created_at",
+		statusName:        "status",
+		statusUpdatedName: "status_updated_at",
+		deletedName:       "deleted_at",
+		historyName:       "password_history",
+		mobileName:        "mobile",
+		scopesName:        "scopes",
 	}
 }
 
-func NewUserStore(session *mgo.Session, db, collection string, userCfg *MgoUserCfg) (us *MgoUserStore) {
+func NewUserStore(session *mgo.Session, db, collection string, userCfg *MgoUserCfg, opts ...StoreOption) (us *MgoUserStore) {
 	if !o2x.IsUserType(userCfg.userType) {
 		panic("invalid user type")
 	}
+	options := newStoreOptions(opts)
 	us = &MgoUserStore{
-		session:          session,
-		db:               db,
-		collection:       collection,
-		mobileCollection: collection + "_mobile",
-		userCfg:          userCfg,
+		session:    session,
+		db:         db,
+		collection: collection,
+		userCfg:    userCfg,
+		cache:      options.cache,
+		scopeCache: options.scopeCache,
 	}
 
-	err := session.DB(us.db).C(us.mobileCollection).EnsureIndex(mgo.Index{
-		Key:    []string{"mobile"},
-		Unique: true,
-	})
-	if err != nil {
-		panic(err)
+	for _, idf := range userCfg.identifiers {
+		err := session.DB(us.db).C(us.identifierCollection(idf.Kind)).EnsureIndex(mgo.Index{
+			Key:    []string{idf.Kind},
+			Unique: true,
+		})
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	return
 }
 
-/*
-绑定一条用户手机记录
-*/
-func (us *MgoUserStore) lockUserMobile(session *mgo.Session, userId, mobile string) (err error) {
-	if userId == "" || mobile == "" {
-		err = o2x.ErrValueRequired
-		return
-	}
-	c := session.DB(us.db).C(us.mobileCollection)
-	userMobile := &MgoUserMobile{
-		Id:     userId,
-		Mobile: mobile,
-	}
-	err = c.Insert(userMobile)
-	return
+// CacheStats statistics of the user cache
+func (us *MgoUserStore) CacheStats() CacheStats {
+	return us.cache.Stats()
 }
 
-func (us *MgoUserStore) unlockUserMobile(session *mgo.Session, userId string) (err error) {
-	if userId == "" {
-		err = o2x.ErrValueRequired
+// SetInvalidationBus publish cache evictions to other instances and evict the ones published by them
+func (us *MgoUserStore) SetInvalidationBus(bus *InvalidationBus) {
+	us.bus = bus
+	bus.Subscribe(InvalidateUser, us.removeUserCacheKey)
+	bus.Subscribe(InvalidateScopes, func(string) { us.flushScopeCache() })
+}
+
+// publish the eviction of all the id forms of a user
+func (us *MgoUserStore) publish(ids ...interface{}) {
+	if us.bus == nil {
 		return
 	}
-	c := session.DB(us.db).C(us.mobileCollection)
-	mgoErr := c.RemoveId(userId)
-	if mgoErr != mgo.ErrNotFound {
-		err = mgoErr
+	var keys []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		key := fmt.Sprint(id)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if err := us.bus.Publish(InvalidateUser, keys...); err != nil {
+		glog.Warningf("publish user %v invalidation error: %v", ids, err)
 	}
-	return
 }
 
 /*
 保存用户并将用户信息存入缓存cache
+
+The unique identifiers are claimed before inserting the user and released again if the user cannot be inserted,
+mgo does not support multi-document transactions.
+Returns the ErrDuplicate error of the identifier or ErrDuplicateUserID on duplicated keys.
 */
 func (us *MgoUserStore) Save(u o2x.User) (err error) {
 	session := us.session.Clone()
 	defer session.Close()
 
-	if u.GetMobile() != "" {
-		err = us.lockUserMobile(session, u.GetID(), u.GetMobile())
-		if err != nil {
-			return
-		}
+	values, err := us.identifierValues(u)
+	if err != nil {
+		return
+	}
+	claimed, err := us.claimIdentifiers(session, u.GetID(), values)
+	if err != nil {
+		return
+	}
+	doc, err := userDocument(u)
+	if err != nil {
+		us.rollbackIdentifiers(session, u.GetID(), claimed)
+		return
+	}
+	if _, ok := doc[us.userCfg.createdName]; !ok {
+		doc[us.userCfg.createdName] = time.Now()
 	}
 	c := session.DB(us.db).C(us.collection)
 	glog.Infof("insert user:%v", u)
-	err = c.Insert(u)
+	err = c.Insert(doc)
 
 	if err != nil {
+		us.rollbackIdentifiers(session, u.GetID(), claimed)
+		if mgo.IsDup(err) {
+			err = ErrDuplicateUserID
+		}
 		return
 	}
-	addUserCache(u)
+	// the not found entries of the user
+	us.removeUserCache(u.GetID())
+	us.removeUserCache(u.GetUserID())
+	if visibleStatus(documentStatus(doc, us.userCfg.statusName)) {
+		us.addUserCache(u)
+	}
+	us.publish(u.GetID(), u.GetUserID())
 
 	return
 }
 
 func (us *MgoUserStore) Remove(id interface{}) (err error) {
-	removeUserCache(id)
+	us.removeUserCache(id)
 
 	session := us.session.Clone()
 	defer session.Close()
@@ -166,8 +240,10 @@ func (us *MgoUserStore) Remove(id interface{}) (err error) {
 	}
 	glog.Infof("remove user:%v", id)
 
-	//解绑用户手机
-	us.unlockUserMobile(session, sid)
+	//解绑用户手机等唯一标识
+	if _, err = us.releaseIdentifiers(session, sid); err != nil {
+		return
+	}
 
 	//删除用户，先用objectId 如果不成，后用string类型
 	mgoErr := c.RemoveId(id)
@@ -182,26 +258,87 @@ func (us *MgoUserStore) Remove(id interface{}) (err error) {
 		err = o2x.ErrNotFound
 		return
 	}
-	err = mgoErr
+	if err = mgoErr; err == nil {
+		us.publish(id)
+	}
 	return
 }
 
+// Find find a user, deleted users are not found and suspended users return ErrUserSuspended,
+// use FindAny to find users of any status.
+// Concurrent loads of a user are coalesced, stale cached users are returned while refreshed in background.
 func (us *MgoUserStore) Find(id interface{}) (u o2x.User, err error) {
-	if u = getUserCache(id); u != nil {
+	key := fmt.Sprint(id)
+	if u, found, stale := us.getUserCache(id); found {
+		if stale {
+			us.flight.Refresh(key, us.loader(id))
+		}
+		if u == nil {
+			return nil, o2x.ErrNotFound
+		}
+		return u, nil
+	}
+
+	v, err, _ := us.flight.Do(key, us.loader(id))
+	if err != nil {
+		return
+	}
+	loaded, ok := v.(*loadedUser)
+	if !ok {
+		return nil, errFlightResult
+	}
+	switch loaded.status {
+	case UserStatusDeleted, userStatusPurging:
+		err = o2x.ErrNotFound
 		return
+	case UserStatusSuspended:
+		err = ErrUserSuspended
+		return
+	}
+	return loaded.user, nil
+}
+
+// a user loaded by Find
+type loadedUser struct {
+	user   o2x.User
+	status UserStatus
+}
+
+// loader load a user from the database into the cache, only users of a visible status are cached.
+// The result is not cached if a user has been invalidated while loading, e.g. created by Save.
+func (us *MgoUserStore) loader(id interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		gen := us.cacheGen.current()
+		user, status, err := us.FindAny(id)
+		if err == o2x.ErrNotFound {
+			us.cacheGen.setIf(gen, func() { us.cache.Set(fmt.Sprint(id), nil) })
+		}
+		if err != nil {
+			return nil, err
+		}
+		if visibleStatus(status) {
+			us.cacheGen.setIf(gen, func() { us.addUserCache(user) })
+		} else {
+			us.removeUserCache(id)
+			us.removeUserCache(user.GetUserID())
+		}
+		return &loadedUser{user: user, status: status}, nil
 	}
+}
 
+// FindAny find a user of any status, bypassing the cache
+func (us *MgoUserStore) FindAny(id interface{}) (u o2x.User, status UserStatus, err error) {
 	session := us.session.Clone()
 	defer session.Close()
 	c := session.DB(us.db).C(us.collection)
 
-	user := o2x.NewUser(us.userCfg.userType)
-	mgoErr := c.FindId(id).One(user)
+	raw := bson.Raw{}
+	mgoErr := c.FindId(id).One(&raw)
 	if mgoErr != nil && mgoErr == mgo.ErrNotFound {
 		// try to find using object id
 		if sid, ok := id.(string); ok && bson.IsObjectIdHex(sid) {
 			bid := bson.ObjectIdHex(sid)
-			mgoErr = c.FindId(bid).One(user)
+			mgoErr = c.FindId(bid).One(&raw)
 		}
 	}
 
@@ -211,34 +348,25 @@ func (us *MgoUserStore) Find(id interface{}) (u o2x.User, err error) {
 			return
 		}
 		err = mgoErr
+		return
 	}
 
-	if err != nil {
+	user := o2x.NewUser(us.userCfg.userType)
+	if err = raw.Unmarshal(user); err != nil {
 		return
 	}
-
-	u = user
-
-	if u != nil {
-		addUserCache(u)
+	doc := bson.M{}
+	if err = raw.Unmarshal(doc); err != nil {
+		return
 	}
-
+	status = documentStatus(doc, us.userCfg.statusName)
+	u = user
 	return
 }
 
+// FindMobile find user by the mobile identifier
 func (us *MgoUserStore) FindMobile(mobile string) (u o2x.User, err error) {
-	session := us.session.Clone()
-	defer session.Close()
-	c := session.DB(us.db).C(us.collection)
-
-	user := o2x.NewUser(us.userCfg.userType)
-	mgoErr := c.Find(bson.M{"mobile": mobile}).One(user)
-	if mgoErr != nil && mgoErr == mgo.ErrNotFound {
-		err = o2x.ErrNotFound
-		return
-	}
-	u = user
-	return
+	return us.FindByIdentifier(IdentifierMobile, mobile)
 }
 
 func (us *MgoUserStore) UpdatePwd(id interface{}, password string) (err error) {
@@ -246,7 +374,24 @@ func (us *MgoUserStore) UpdatePwd(id interface{}, password string) (err error) {
 	if err != nil {
 		return
 	}
+	if err = us.checkPassword(user, password); err != nil {
+		return
+	}
+	push, err := us.historyUpdate(password)
+	if err != nil {
+		return
+	}
 	glog.Infof("update user password %v", id)
+
+	if us.hasher != nil {
+		err = us.updatePasswordHash(user, password, nil, push)
+		if err != nil {
+			return
+		}
+		us.publish(id, user.GetUserID())
+		return
+	}
+
 	user.SetRawPassword(password)
 
 	session := us.session.Clone()
@@ -255,12 +400,16 @@ func (us *MgoUserStore) UpdatePwd(id interface{}, password string) (err error) {
 
 	bs := bson.M{us.userCfg.passwordName: user.GetPassword(), us.userCfg.saltName: user.GetSalt()}
 	bs = bson.M{"$set": bs}
+	if push != nil {
+		bs["$push"] = push
+	}
 	err = c.UpdateId(user.GetUserID(), bs)
 
 	if err != nil {
 		return
 	}
-	addUserCache(user)
+	us.addUserCache(user)
+	us.publish(id, user.GetUserID())
 	return
 }
 
@@ -275,7 +424,7 @@ func (us *MgoUserStore) UpdateScope(id interface{}, clientId, scope string) (err
 	defer session.Close()
 	c := session.DB(us.db).C(us.collection)
 
-	bs := bson.M{"scopes." + clientId: scope}
+	bs := bson.M{us.userCfg.scopesName + "." + clientId: scope}
 	bs = bson.M{"$set": bs}
 	err = c.UpdateId(user.GetUserID(), bs)
 
@@ -283,11 +432,14 @@ func (us *MgoUserStore) UpdateScope(id interface{}, clientId, scope string) (err
 		return
 	}
 
+	us.removeUserCache(id)
+	us.removeUserCache(user.GetUserID())
 	user, err = us.Find(id)
 	if err != nil {
 		return
 	}
 
-	addUserCache(user)
+	us.addUserCache(user)
+	us.publish(id, user.GetUserID())
 	return
 }
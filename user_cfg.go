@@ -0,0 +1,206 @@
+// authors: wangoo
+// created: 2026-10-19
+// user store configuration of custom user types
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"reflect"
+	"strings"
+)
+
+var (
+	bytesType     = reflect.TypeOf([]byte(nil))
+	stringType    = reflect.TypeOf("")
+	stringsType   = reflect.TypeOf([]string(nil))
+	scopesMapType = reflect.TypeOf(map[string]string(nil))
+	intTypes      = []reflect.Type{reflect.TypeOf(0), reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0))}
+)
+
+// UserCfgError an invalid user configuration
+type UserCfgError struct {
+	Field  string
+	Reason string
+}
+
+func (e *UserCfgError) Error() string {
+	return "invalid user cfg field " + e.Field + ": " + e.Reason
+}
+
+// MgoUserCfgBuilder build the configuration of a user type implementing o2x.User,
+// mapping the fields used by the user store to the bson fields of the type
+type MgoUserCfgBuilder struct {
+	cfg *MgoUserCfg
+
+	// fields mapped explicitly, which must be declared by the user type
+	mapped map[string]bool
+}
+
+// NewMgoUserCfgBuilder start a configuration of the type of the user, a pointer to a struct such as &MyUser{}.
+// The field names default to the ones of o2x.SimpleUser and DefaultMgoUserCfg.
+func NewMgoUserCfgBuilder(user o2x.User) *MgoUserCfgBuilder {
+	cfg := DefaultMgoUserCfg()
+	cfg.userType = reflect.TypeOf(user)
+	return &MgoUserCfgBuilder{cfg: cfg, mapped: make(map[string]bool)}
+}
+
+func (b *MgoUserCfgBuilder) mapField(field string, name *string, value string) *MgoUserCfgBuilder {
+	*name = value
+	b.mapped[field] = true
+	return b
+}
+
+// PasswordField the bson field of the password
+func (b *MgoUserCfgBuilder) PasswordField(name string) *MgoUserCfgBuilder {
+	return b.mapField("password", &b.cfg.passwordName, name)
+}
+
+// SaltField the bson field of the password salt
+func (b *MgoUserCfgBuilder) SaltField(name string) *MgoUserCfgBuilder {
+	return b.mapField("salt", &b.cfg.saltName, name)
+}
+
+// MobileField the bson field of the mobile
+func (b *MgoUserCfgBuilder) MobileField(name string) *MgoUserCfgBuilder {
+	return b.mapField("mobile", &b.cfg.mobileName, name)
+}
+
+// ScopesField the bson field of the scopes by client
+func (b *MgoUserCfgBuilder) ScopesField(name string) *MgoUserCfgBuilder {
+	return b.mapField("scopes", &b.cfg.scopesName, name)
+}
+
+// HashField the bson field of the password hash in PHC string format
+func (b *MgoUserCfgBuilder) HashField(name string) *MgoUserCfgBuilder {
+	return b.mapField("hash", &b.cfg.hashName, name)
+}
+
+// HistoryField the bson field of the password history
+func (b *MgoUserCfgBuilder) HistoryField(name string) *MgoUserCfgBuilder {
+	return b.mapField("history", &b.cfg.historyName, name)
+}
+
+// VersionField the bson field of the version increased by Update
+func (b *MgoUserCfgBuilder) VersionField(name string) *MgoUserCfgBuilder {
+	return b.mapField("version", &b.cfg.versionName, name)
+}
+
+// CreatedField the bson field of the creation time
+func (b *MgoUserCfgBuilder) CreatedField(name string) *MgoUserCfgBuilder {
+	return b.mapField("created", &b.cfg.createdName, name)
+}
+
+// StatusField the bson fields of the status, the status change time and the soft deletion time
+func (b *MgoUserCfgBuilder) StatusField(status, updated, deleted string) *MgoUserCfgBuilder {
+	b.mapField("status", &b.cfg.statusName, status)
+	b.mapField("status_updated", &b.cfg.statusUpdatedName, updated)
+	return b.mapField("deleted", &b.cfg.deletedName, deleted)
+}
+
+// Identifiers add unique identifiers, replacing the ones of the same kind
+func (b *MgoUserCfgBuilder) Identifiers(identifiers ...*UniqueIdentifier) *MgoUserCfgBuilder {
+	b.cfg.AddIdentifiers(identifiers...)
+	return b
+}
+
+// a mapped field, required if the user type must declare it
+type userField struct {
+	field    string
+	name     string
+	required bool
+	types    []reflect.Type
+}
+
+// Build validate the field mappings against the user type and return the configuration
+func (b *MgoUserCfgBuilder) Build() (cfg *MgoUserCfg, err error) {
+	c := *b.cfg
+	cfg = &c
+
+	t := cfg.userType
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || !o2x.IsUserType(t) {
+		return nil, &UserCfgError{Field: "user", Reason: "not a pointer to a struct implementing o2x.User"}
+	}
+
+	// the mobile identifier stores the mobile field
+	cfg.identifiers = make([]*UniqueIdentifier, len(b.cfg.identifiers))
+	for i, idf := range b.cfg.identifiers {
+		if idf.Kind == IdentifierMobile && idf.Field != cfg.mobileName {
+			mobile := *idf
+			mobile.Field = cfg.mobileName
+			idf = &mobile
+		}
+		cfg.identifiers[i] = idf
+	}
+
+	// the fields read through o2x.User are declared by the type, the ones written by the store
+	// are kept outside of the type unless declared, a field mapped explicitly must be declared
+	types := bsonFieldTypes(t)
+	fields := []userField{
+		{"password", cfg.passwordName, true, []reflect.Type{bytesType}},
+		{"salt", cfg.saltName, true, []reflect.Type{bytesType}},
+		{"mobile", cfg.mobileName, true, []reflect.Type{stringType}},
+		{"scopes", cfg.scopesName, true, []reflect.Type{scopesMapType}},
+		{"hash", cfg.hashName, false, []reflect.Type{stringType}},
+		{"history", cfg.historyName, false, []reflect.Type{stringsType}},
+		{"version", cfg.versionName, false, intTypes},
+		{"created", cfg.createdName, false, []reflect.Type{timeType}},
+		{"status", cfg.statusName, false, []reflect.Type{stringType}},
+		{"status_updated", cfg.statusUpdatedName, false, []reflect.Type{timeType}},
+		{"deleted", cfg.deletedName, false, []reflect.Type{timeType}},
+	}
+	for _, idf := range cfg.identifiers {
+		fields = append(fields, userField{idf.Kind, idf.Field, true, []reflect.Type{stringType}})
+	}
+
+	used := make(map[string]string)
+	for _, f := range fields {
+		if err = checkUserField(types, f.field, f.name, f.required || b.mapped[f.field], f.types...); err != nil {
+			return nil, err
+		}
+		if other, ok := used[f.name]; ok && other != f.field {
+			return nil, &UserCfgError{Field: f.field, Reason: "same bson field as " + other}
+		}
+		used[f.name] = f.field
+	}
+	return
+}
+
+// check the bson field of the type, a field not required may be undeclared by the type
+func checkUserField(types map[string]reflect.Type, field, name string, required bool, allowed ...reflect.Type) error {
+	if name == "" || name == "_id" || strings.ContainsAny(name, ".$") {
+		return &UserCfgError{Field: field, Reason: "invalid bson field name " + name}
+	}
+	t, ok := types[name]
+	if !ok {
+		if !required {
+			return nil
+		}
+		return &UserCfgError{Field: field, Reason: "no bson field " + name}
+	}
+	for _, a := range allowed {
+		if sameKind(t, a) {
+			return nil
+		}
+	}
+	return &UserCfgError{Field: field, Reason: "bson field " + name + " is not a " + allowed[0].String()}
+}
+
+// compare by kind and element kinds, structs such as time.Time by type
+func sameKind(t, a reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != a.Kind() {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return t.Elem().Kind() == a.Elem().Kind()
+	case reflect.Map:
+		return t.Key().Kind() == a.Key().Kind() && t.Elem().Kind() == a.Elem().Kind()
+	case reflect.Struct:
+		return t == a
+	}
+	return true
+}
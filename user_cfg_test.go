@@ -0,0 +1,129 @@
+// authors: wangoo
+// created: 2026-10-19
+// user cfg builder test
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a user type with its own field names
+type accountUser struct {
+	ID      string            `bson:"_id"`
+	Phone   string            `bson:"phone"`
+	Email   string            `bson:"email"`
+	Pwd     []byte            `bson:"pwd"`
+	Seed    []byte            `bson:"pwd_salt"`
+	Grants  map[string]string `bson:"grants"`
+	Created time.Time         `bson:"created"`
+	Rev     int64             `bson:"rev"`
+	State   string            `bson:"state"`
+	StateAt time.Time         `bson:"state_at"`
+	Age     int               `bson:"age"`
+}
+
+func (u *accountUser) GetUserID() interface{}       { return u.ID }
+func (u *accountUser) SetUserID(id interface{})     { u.ID, _ = o2x.UserIdString(id) }
+func (u *accountUser) GetID() string                { return u.ID }
+func (u *accountUser) GetMobile() string            { return u.Phone }
+func (u *accountUser) SetMobile(mobile string)      { u.Phone = mobile }
+func (u *accountUser) GetPassword() []byte          { return u.Pwd }
+func (u *accountUser) SetPassword(p []byte)         { u.Pwd = p }
+func (u *accountUser) GetSalt() []byte              { return u.Seed }
+func (u *accountUser) SetSalt(salt []byte)          { u.Seed = salt }
+func (u *accountUser) SetRawPassword(string)        {}
+func (u *accountUser) Match(string) bool            { return false }
+func (u *accountUser) GetScopes() map[string]string { return u.Grants }
+
+func accountBuilder() *MgoUserCfgBuilder {
+	return NewMgoUserCfgBuilder(&accountUser{}).
+		PasswordField("pwd").
+		SaltField("pwd_salt").
+		MobileField("phone").
+		ScopesField("grants")
+}
+
+func TestMgoUserCfgBuilder(t *testing.T) {
+	cfg, err := NewMgoUserCfgBuilder(&o2x.SimpleUser{}).Build()
+	assert.Nil(t, err)
+	assert.Equal(t, o2x.SimpleUserPtrType, cfg.userType)
+	assert.Equal(t, "scopes", cfg.scopesName)
+	assert.Equal(t, "mobile", cfg.identifiers[0].Field)
+
+	cfg, err = accountBuilder().CreatedField("created").VersionField("rev").Identifiers(EmailIdentifier()).Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(&accountUser{}), cfg.userType)
+	assert.Equal(t, "created", cfg.createdName)
+	assert.Equal(t, "rev", cfg.versionName)
+	// the store fields not declared by the type keep their defaults
+	assert.Equal(t, "password_hash", cfg.hashName)
+	assert.Len(t, cfg.identifiers, 2)
+	assert.Equal(t, "phone", cfg.identifiers[0].Field)
+	assert.Equal(t, "email", cfg.identifiers[1].Field)
+
+	// the mapped fields hold the values of the o2x.User getters
+	user := &accountUser{ID: "u1", Phone: "133 4455 6677", Email: "A@b.c", Pwd: []byte("p"), Seed: []byte("s"),
+		Grants: map[string]string{"c1": "read"}}
+	doc, err := userDocument(user)
+	assert.Nil(t, err)
+	assert.Equal(t, user.GetMobile(), doc[cfg.mobileName])
+	assert.Equal(t, user.GetPassword(), doc[cfg.passwordName])
+	assert.Equal(t, user.GetSalt(), doc[cfg.saltName])
+	assert.Equal(t, bson.M{"c1": "read"}, doc[cfg.scopesName])
+
+	us := &MgoUserStore{collection: "user", userCfg: cfg}
+	values, err := us.identifierValues(user)
+	assert.Nil(t, err)
+	assert.Len(t, values, 2)
+	assert.Equal(t, "13344556677", values[0].value)
+	assert.Equal(t, "a@b.c", values[1].value)
+	field, err := us.scopeField("c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "grants.c1", field)
+	assert.Equal(t, "phone", us.mobileField())
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"grants.c2": "view"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"pwd": []byte("x")}}))
+}
+
+func TestMgoUserCfgBuilderInvalid(t *testing.T) {
+	check := func(b *MgoUserCfgBuilder, field string) {
+		cfg, err := b.Build()
+		assert.Nil(t, cfg)
+		if assert.IsType(t, &UserCfgError{}, err) {
+			assert.Equal(t, field, err.(*UserCfgError).Field)
+		}
+	}
+
+	// default names missing in the type
+	check(NewMgoUserCfgBuilder(&accountUser{}), "password")
+	check(NewMgoUserCfgBuilder(nil), "user")
+
+	check(accountBuilder().PasswordField("missing"), "password")
+	check(accountBuilder().PasswordField(""), "password")
+	check(accountBuilder().SaltField("pwd.salt"), "salt")
+	check(accountBuilder().SaltField("phone"), "salt")
+	check(accountBuilder().MobileField("age"), "mobile")
+	check(accountBuilder().ScopesField("$grants"), "scopes")
+	check(accountBuilder().ScopesField("email"), "scopes")
+	check(accountBuilder().SaltField("pwd"), "salt")
+	check(accountBuilder().Identifiers(UsernameIdentifier()), IdentifierUsername)
+
+	// the store fields mapped explicitly must be declared, with the right type
+	check(accountBuilder().CreatedField("craeted"), "created")
+	check(accountBuilder().CreatedField("phone"), "created")
+	check(accountBuilder().VersionField("email"), "version")
+	check(accountBuilder().HashField("age"), "hash")
+	check(accountBuilder().HistoryField(""), "history")
+	check(accountBuilder().StatusField("age", "state_at", "state_at"), "status")
+	check(accountBuilder().StatusField("state", "state_at", "state_at"), "deleted")
+	check(accountBuilder().StatusField("state", "status_updated_at", "deleted_at"), "status_updated")
+	check(accountBuilder().StatusField("state", "state_at", "deleted"), "deleted")
+	check(accountBuilder().VersionField("pwd"), "version")
+}
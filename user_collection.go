@@ -0,0 +1,67 @@
+// authors: wangoo
+// created: 2026-10-19
+// side collections of the user store
+
+package o2m
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// suffixes of the side collections of a user store, named <collection>_<suffix>
+const (
+	collectionTOTP              = "totp"
+	collectionWebAuthn          = "webauthn"
+	collectionWebAuthnChallenge = "webauthn_challenge"
+	collectionVerification      = "verification"
+	collectionLoginFailure      = "login_failure"
+	collectionFederated         = "federated"
+	collectionRole              = "role"
+	collectionGroup             = "group"
+	collectionDeletion          = "deletion"
+)
+
+var sideCollections = []string{
+	collectionTOTP,
+	collectionWebAuthn,
+	collectionWebAuthnChallenge,
+	collectionVerification,
+	collectionLoginFailure,
+	collectionFederated,
+	collectionRole,
+	collectionGroup,
+	collectionDeletion,
+}
+
+// side collections holding documents of a user, removed with the user
+var userDataCollections = []string{
+	collectionTOTP,
+	collectionWebAuthn,
+	collectionVerification,
+	collectionFederated,
+}
+
+func (us *MgoUserStore) sideCollection(suffix string) string {
+	return us.collection + "_" + suffix
+}
+
+// identifier kinds cannot be side collection suffixes, identifier claims are stored in <collection>_<kind>
+func reservedIdentifierKind(kind string) bool {
+	for _, suffix := range sideCollections {
+		if kind == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// selector of the documents of the user in a side collection
+func userDataSelector(suffix, uid string) bson.M {
+	switch suffix {
+	case collectionTOTP:
+		return bson.M{"_id": uid}
+	case collectionGroup:
+		return bson.M{"users": uid}
+	}
+	return bson.M{"user_id": uid}
+}
@@ -0,0 +1,310 @@
+// authors: wangoo
+// created: 2026-10-19
+// cascading user deletion
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// deletion steps, run in this order, the user document last
+const (
+	DeletionStepTokens      = "tokens"
+	DeletionStepConsents    = "consents"
+	DeletionStepClients     = "clients"
+	DeletionStepCredentials = "credentials"
+	DeletionStepIdentifiers = "identifiers"
+	DeletionStepUser        = "user"
+)
+
+var deletionSteps = []string{
+	DeletionStepTokens,
+	DeletionStepConsents,
+	DeletionStepClients,
+	DeletionStepCredentials,
+	DeletionStepIdentifiers,
+	DeletionStepUser,
+}
+
+// UserDataRemover remove the data of a user, returns the number of removed documents,
+// implemented by MgoTokenStore and MgoAuthStore
+type UserDataRemover interface {
+	RemoveByUser(userID string) (int, error)
+}
+
+// UserDeletionReport what is removed for a user, also the job document of a resumable deletion
+type UserDeletionReport struct {
+	UserID string `bson:"_id" json:"user_id"`
+
+	// completed steps
+	Steps []string `bson:"steps" json:"steps"`
+
+	Tokens   int `bson:"tokens" json:"tokens"`
+	Consents int `bson:"consents" json:"consents"`
+
+	// owned clients, removed or anonymized
+	Clients           []string `bson:"clients,omitempty" json:"clients,omitempty"`
+	ClientsAnonymized bool     `bson:"clients_anonymized" json:"clients_anonymized"`
+
+	// totp, webauthn credentials, verification tokens, login failures and federated identities
+	Credentials int  `bson:"credentials" json:"credentials"`
+	Identifiers int  `bson:"identifiers" json:"identifiers"`
+	User        bool `bson:"user" json:"user"`
+
+	StartedAt  time.Time `bson:"started_at" json:"started_at"`
+	FinishedAt time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// Done whether the step is completed
+func (r *UserDeletionReport) Done(step string) bool {
+	for _, s := range r.Steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Finished whether all the steps are completed
+func (r *UserDeletionReport) Finished() bool {
+	return !r.FinishedAt.IsZero()
+}
+
+// UserDeleter remove a user and the user data in all the stores.
+// Each step is recorded in the collection <collection>_deletion of the user store,
+// Delete resumes an interrupted deletion from the first incomplete step.
+type UserDeleter struct {
+	users      *MgoUserStore
+	tokens     UserDataRemover
+	consents   UserDataRemover
+	clients    *MongoClientStore
+	collection string
+
+	// remove the owned clients instead of removing their owner
+	removeClients bool
+}
+
+// NewUserDeleter create a user deleter, the token, consent and client stores can be nil.
+// Owned clients are anonymized unless SetRemoveClients is called.
+func NewUserDeleter(users *MgoUserStore, tokens, consents UserDataRemover, clients *MongoClientStore) *UserDeleter {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	return &UserDeleter{
+		users:      users,
+		tokens:     tokens,
+		consents:   consents,
+		clients:    clients,
+		collection: users.sideCollection(collectionDeletion),
+	}
+}
+
+// SetRemoveClients remove the clients owned by deleted users, and their tokens and consents
+func (d *UserDeleter) SetRemoveClients(remove bool) {
+	d.removeClients = remove
+}
+
+// Report the report of the deletion of a user, o2x.ErrNotFound if never deleted
+func (d *UserDeleter) Report(id interface{}) (report *UserDeletionReport, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := d.users.session.Clone()
+	defer session.Close()
+
+	report = &UserDeletionReport{}
+	err = session.DB(d.users.db).C(d.collection).FindId(uid).One(report)
+	if err == mgo.ErrNotFound {
+		return nil, o2x.ErrNotFound
+	}
+	return
+}
+
+// Delete remove the user everywhere, or resume the interrupted deletion of the user.
+// The job of a finished deletion is replaced, in case the user id is used again.
+func (d *UserDeleter) Delete(id interface{}) (report *UserDeletionReport, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	if uid == "" {
+		return nil, o2x.ErrValueRequired
+	}
+
+	session := d.users.session.Clone()
+	defer session.Close()
+	c := session.DB(d.users.db).C(d.collection)
+
+	// a finished job of a previous deletion is started again
+	report = &UserDeletionReport{}
+	_, err = c.Find(bson.M{"_id": uid, "finished_at": bson.M{"$exists": true}}).Apply(mgo.Change{Remove: true}, report)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	report = &UserDeletionReport{}
+	_, err = c.FindId(uid).Apply(mgo.Change{
+		Update:    bson.M{"$setOnInsert": bson.M{"started_at": time.Now(), "steps": []string{}}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, report)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Steps) > 0 {
+		glog.Infof("resume user %v deletion after %v", uid, report.Steps)
+	}
+
+	for _, step := range deletionSteps {
+		if report.Done(step) {
+			continue
+		}
+		set, err := d.run(session, uid, step, report)
+		if err != nil {
+			glog.Errorf("delete user %v step %v error: %v", uid, step, err)
+			return report, err
+		}
+		report.Steps = append(report.Steps, step)
+		err = c.UpdateId(uid, bson.M{"$set": set, "$addToSet": bson.M{"steps": step}})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	if err = c.UpdateId(uid, bson.M{"$set": bson.M{"finished_at": report.FinishedAt}}); err != nil {
+		return
+	}
+	glog.Infof("user %v deleted: %+v", uid, report)
+	return
+}
+
+// Purge delete the user if soft deleted before the cutoff, or resume its interrupted purge.
+// The user is first claimed by a conditional status update so that it cannot be restored,
+// o2x.ErrNotFound is returned without deleting if the user is not soft deleted before the cutoff.
+func (d *UserDeleter) Purge(id interface{}, cutoff time.Time) (report *UserDeletionReport, err error) {
+	if err = d.users.claimPurge(id, cutoff); err != nil {
+		return
+	}
+	return d.Delete(id)
+}
+
+// run a step, updating the report and returning the report fields to save
+func (d *UserDeleter) run(session *mgo.Session, uid, step string, report *UserDeletionReport) (set bson.M, err error) {
+	switch step {
+	case DeletionStepTokens:
+		if d.tokens != nil {
+			report.Tokens, err = d.tokens.RemoveByUser(uid)
+		}
+		return bson.M{"tokens": report.Tokens}, err
+	case DeletionStepConsents:
+		if d.consents != nil {
+			report.Consents, err = d.consents.RemoveByUser(uid)
+		}
+		return bson.M{"consents": report.Consents}, err
+	case DeletionStepClients:
+		err = d.deleteClients(uid, report)
+		return bson.M{"clients": report.Clients, "clients_anonymized": report.ClientsAnonymized}, err
+	case DeletionStepCredentials:
+		report.Credentials, err = d.deleteCredentials(session, uid)
+		return bson.M{"credentials": report.Credentials}, err
+	case DeletionStepIdentifiers:
+		report.Identifiers, err = d.users.releaseIdentifiers(session, uid)
+		return bson.M{"identifiers": report.Identifiers}, err
+	case DeletionStepUser:
+		report.User, err = d.deleteUser(session, uid)
+		return bson.M{"user": report.User}, err
+	}
+	return
+}
+
+func (d *UserDeleter) deleteClients(uid string, report *UserDeletionReport) (err error) {
+	if d.clients == nil {
+		return
+	}
+	var ids []string
+	if d.removeClients {
+		ids, err = d.clients.RemoveByUser(uid)
+	} else {
+		ids, err = d.clients.AnonymizeByUser(uid)
+	}
+	// the clients of a previous interrupted run are kept
+	report.Clients = append(report.Clients, ids...)
+	report.ClientsAnonymized = !d.removeClients
+	if err != nil || !d.removeClients {
+		return
+	}
+
+	for _, id := range ids {
+		for _, remover := range []UserDataRemover{d.tokens, d.consents} {
+			if revoker, ok := remover.(ClientRevoker); ok {
+				if err = revoker.RemoveByClient(id); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// remove the user data of the side collections of the user store
+func (d *UserDeleter) deleteCredentials(session *mgo.Session, uid string) (n int, err error) {
+	us := d.users
+	db := session.DB(us.db)
+	for _, suffix := range userDataCollections {
+		info, err := db.C(us.sideCollection(suffix)).RemoveAll(userDataSelector(suffix, uid))
+		if err != nil {
+			return n, err
+		}
+		n += info.Removed
+	}
+
+	// the login failures of the user and of the mobile, the user document is removed at the last step
+	mobile := ""
+	if user, _, err := us.FindAny(uid); err == nil {
+		mobile = user.GetMobile()
+	} else if err != o2x.ErrNotFound {
+		return n, err
+	}
+	info, err := db.C(us.sideCollection(collectionLoginFailure)).RemoveAll(bson.M{"_id": bson.M{"$in": us.loginFailureKeys(uid, mobile)}})
+	if err != nil {
+		return
+	}
+	n += info.Removed
+
+	// the group memberships
+	_, err = db.C(us.sideCollection(collectionGroup)).UpdateAll(userDataSelector(collectionGroup, uid), bson.M{"$pull": bson.M{"users": uid}})
+	d.users.flushScopeCache()
+	return
+}
+
+// remove the user document by the string or the object id
+func (d *UserDeleter) deleteUser(session *mgo.Session, uid string) (removed bool, err error) {
+	us := d.users
+	c := session.DB(us.db).C(us.collection)
+
+	err = c.RemoveId(uid)
+	if err == mgo.ErrNotFound && bson.IsObjectIdHex(uid) {
+		err = c.RemoveId(bson.ObjectIdHex(uid))
+	}
+	if err == mgo.ErrNotFound {
+		err = nil
+	} else if err == nil {
+		removed = true
+	}
+
+	us.removeUserCache(uid)
+	keys := []interface{}{uid}
+	if bson.IsObjectIdHex(uid) {
+		oid := bson.ObjectIdHex(uid)
+		us.removeUserCache(oid)
+		keys = append(keys, oid)
+	}
+	us.publish(keys...)
+	return
+}
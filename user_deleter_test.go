@@ -0,0 +1,39 @@
+// authors: wangoo
+// created: 2026-10-19
+// user deleter test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+func TestUserDeletionReport(t *testing.T) {
+	report := &UserDeletionReport{UserID: "u1"}
+	assert.False(t, report.Done(DeletionStepTokens))
+	assert.False(t, report.Finished())
+
+	report.Steps = []string{DeletionStepTokens, DeletionStepConsents}
+	assert.True(t, report.Done(DeletionStepConsents))
+	assert.False(t, report.Done(DeletionStepUser))
+
+	report.FinishedAt = time.Now()
+	assert.True(t, report.Finished())
+
+	// the user document is removed last
+	assert.Equal(t, DeletionStepUser, deletionSteps[len(deletionSteps)-1])
+}
+
+func TestUserDataSelector(t *testing.T) {
+	assert.Equal(t, bson.M{"_id": "u1"}, userDataSelector(collectionTOTP, "u1"))
+	assert.Equal(t, bson.M{"user_id": "u1"}, userDataSelector(collectionWebAuthn, "u1"))
+	assert.Equal(t, bson.M{"users": "u1"}, userDataSelector(collectionGroup, "u1"))
+
+	us := &MgoUserStore{collection: "user"}
+	assert.Equal(t, "user_login_failure", us.sideCollection(collectionLoginFailure))
+	assert.True(t, reservedIdentifierKind(collectionDeletion))
+	assert.False(t, reservedIdentifierKind(IdentifierEmail))
+}
@@ -0,0 +1,256 @@
+// authors: wangoo
+// created: 2026-10-19
+// data export of a user for data subject access requests
+
+package o2m
+
+import (
+	"encoding/json"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"time"
+)
+
+const (
+	redacted = "[REDACTED]"
+
+	// characters of a token kept by the redaction
+	redactedTokenPrefix = 6
+)
+
+// UserTokenFinder find the tokens of a user, implemented by MgoTokenStore
+type UserTokenFinder interface {
+	FindByUser(userID string) ([]*TokenData, error)
+}
+
+// UserConsentFinder find the consents of a user, implemented by MgoAuthStore
+type UserConsentFinder interface {
+	FindByUser(userID string) ([]*MgoAuth, error)
+}
+
+// ExportedToken a token of a user, the token values are redacted
+type ExportedToken struct {
+	ClientID  string    `json:"client_id"`
+	Scope     string    `json:"scope,omitempty"`
+	Access    string    `json:"access,omitempty"`
+	Refresh   string    `json:"refresh,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// UserExport all the data of a user
+type UserExport struct {
+	UserID     string    `json:"user_id"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	// the user document without the password, salt, hash and password history
+	User bson.M `json:"user"`
+
+	// claimed unique identifiers by kind
+	Identifiers map[string]string `json:"identifiers"`
+
+	// active tokens, expired ones waiting for the ttl index are not exported
+	Tokens   []*ExportedToken `json:"tokens"`
+	Consents []*MgoAuth       `json:"consents"`
+
+	// owned clients, the secrets are redacted
+	Clients []*Oauth2Client `json:"clients"`
+
+	TOTPEnabled         bool                  `json:"totp_enabled"`
+	WebAuthnCredentials []*WebAuthnCredential `json:"webauthn_credentials"`
+	FederatedIdentities []*FederatedIdentity  `json:"federated_identities"`
+	Groups              []string              `json:"groups"`
+}
+
+// UserExporter gather the data of a user in all the stores, the token, consent and client stores can be nil
+type UserExporter struct {
+	users    *MgoUserStore
+	tokens   UserTokenFinder
+	consents UserConsentFinder
+	clients  *MongoClientStore
+}
+
+func NewUserExporter(users *MgoUserStore, tokens UserTokenFinder, consents UserConsentFinder, clients *MongoClientStore) *UserExporter {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	return &UserExporter{
+		users:    users,
+		tokens:   tokens,
+		consents: consents,
+		clients:  clients,
+	}
+}
+
+// redactToken keep only a prefix of a token, to be recognized but not used
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	if len(token) <= 2*redactedTokenPrefix {
+		return redacted
+	}
+	return token[:redactedTokenPrefix] + "..."
+}
+
+func exportToken(t *TokenData) *ExportedToken {
+	et := &ExportedToken{
+		ClientID:  t.ClientID,
+		Scope:     t.Scope,
+		Access:    redactToken(t.Access),
+		Refresh:   redactToken(t.Refresh),
+		Code:      redactToken(t.Code),
+		CreatedAt: t.AccessCreateAt,
+		ExpiredAt: t.ExpiredAt,
+	}
+	if t.Code != "" {
+		et.CreatedAt = t.CodeCreateAt
+	}
+	return et
+}
+
+// the tokens not expired at now
+func activeTokens(tokens []*TokenData, now time.Time) (active []*TokenData) {
+	for _, t := range tokens {
+		if t.ExpiredAt.After(now) {
+			active = append(active, t)
+		}
+	}
+	return
+}
+
+func exportClient(client *Oauth2Client) *Oauth2Client {
+	exported := *client
+	if exported.Secret != "" {
+		exported.Secret = redacted
+	}
+	return &exported
+}
+
+// the fields of the user document not exported
+func (us *MgoUserStore) secretFields() []string {
+	cfg := us.userCfg
+	return []string{cfg.passwordName, cfg.saltName, cfg.hashName, cfg.historyName}
+}
+
+// Export gather the data of a user of any status
+func (e *UserExporter) Export(id interface{}) (export *UserExport, err error) {
+	user, _, err := e.users.FindAny(id)
+	if err != nil {
+		return
+	}
+	uid := user.GetID()
+	us := e.users
+
+	session := us.session.Clone()
+	defer session.Close()
+	db := session.DB(us.db)
+
+	export = &UserExport{
+		UserID:              uid,
+		ExportedAt:          time.Now(),
+		User:                bson.M{},
+		Identifiers:         make(map[string]string),
+		Tokens:              []*ExportedToken{},
+		Consents:            []*MgoAuth{},
+		Clients:             []*Oauth2Client{},
+		WebAuthnCredentials: []*WebAuthnCredential{},
+		FederatedIdentities: []*FederatedIdentity{},
+		Groups:              []string{},
+	}
+
+	if err = db.C(us.collection).FindId(user.GetUserID()).One(&export.User); err != nil {
+		return nil, err
+	}
+	for _, field := range us.secretFields() {
+		delete(export.User, field)
+	}
+
+	for _, idf := range us.userCfg.identifiers {
+		claim := bson.M{}
+		err = db.C(us.identifierCollection(idf.Kind)).FindId(uid).One(&claim)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		export.Identifiers[idf.Kind], _ = claim[idf.Kind].(string)
+	}
+
+	if e.tokens != nil {
+		tokens, err := e.tokens.FindByUser(uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range activeTokens(tokens, export.ExportedAt) {
+			export.Tokens = append(export.Tokens, exportToken(t))
+		}
+	}
+	if e.consents != nil {
+		consents, err := e.consents.FindByUser(uid)
+		if err != nil {
+			return nil, err
+		}
+		export.Consents = append(export.Consents, consents...)
+	}
+	if e.clients != nil {
+		clients, err := e.clients.FindByUser(uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			export.Clients = append(export.Clients, exportClient(client))
+		}
+	}
+
+	err = e.exportCredentials(db, uid, export)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// the data of the side collections of the user store
+func (e *UserExporter) exportCredentials(db *mgo.Database, uid string, export *UserExport) (err error) {
+	us := e.users
+
+	totp := &userTOTP{}
+	err = db.C(us.sideCollection(collectionTOTP)).Find(userDataSelector(collectionTOTP, uid)).One(totp)
+	if err != nil && err != mgo.ErrNotFound {
+		return
+	}
+	export.TOTPEnabled = err == nil && totp.Enabled
+
+	err = db.C(us.sideCollection(collectionWebAuthn)).Find(userDataSelector(collectionWebAuthn, uid)).All(&export.WebAuthnCredentials)
+	if err != nil {
+		return
+	}
+	err = db.C(us.sideCollection(collectionFederated)).Find(userDataSelector(collectionFederated, uid)).All(&export.FederatedIdentities)
+	if err != nil {
+		return
+	}
+
+	var groups []Group
+	err = db.C(us.sideCollection(collectionGroup)).Find(userDataSelector(collectionGroup, uid)).Select(bson.M{"_id": 1}).All(&groups)
+	if err != nil {
+		return
+	}
+	for _, group := range groups {
+		export.Groups = append(export.Groups, group.ID)
+	}
+	return
+}
+
+// ExportJSON write the data of a user as one json document
+func (e *UserExporter) ExportJSON(id interface{}, w io.Writer) (err error) {
+	export, err := e.Export(id)
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
@@ -0,0 +1,50 @@
+// authors: wangoo
+// created: 2026-10-19
+// user export test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRedactToken(t *testing.T) {
+	assert.Equal(t, "", redactToken(""))
+	assert.Equal(t, redacted, redactToken("short"))
+	assert.Equal(t, "NGQ5YT...", redactToken("NGQ5YTgxYjctMjQ2Ni0zMWFhLWJhZGYtMTYyYTJkNmMxNzcz"))
+}
+
+func TestExportToken(t *testing.T) {
+	now := time.Now()
+	et := exportToken(&TokenData{
+		ClientID:       "c1",
+		Scope:          "read",
+		Access:         "access-token-value",
+		Refresh:        "refresh-token-value",
+		AccessCreateAt: now,
+		ExpiredAt:      now.Add(time.Hour),
+	})
+	assert.Equal(t, "c1", et.ClientID)
+	assert.Equal(t, "access...", et.Access)
+	assert.Equal(t, "refres...", et.Refresh)
+	assert.Equal(t, "", et.Code)
+	assert.Equal(t, now, et.CreatedAt)
+
+	client := &Oauth2Client{ID: "c1", Secret: "secret"}
+	exported := exportClient(client)
+	assert.Equal(t, redacted, exported.Secret)
+	assert.Equal(t, "secret", client.Secret)
+
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	assert.Equal(t, []string{"password", "salt", "password_hash", "password_history"}, us.secretFields())
+}
+
+func TestActiveTokens(t *testing.T) {
+	now := time.Now()
+	active := &TokenData{ClientID: "c1", ExpiredAt: now.Add(time.Hour)}
+	expired := &TokenData{ClientID: "c2", ExpiredAt: now.Add(-time.Second)}
+	assert.Equal(t, []*TokenData{active}, activeTokens([]*TokenData{expired, active}, now))
+	assert.Empty(t, activeTokens([]*TokenData{expired}, now))
+}
@@ -0,0 +1,193 @@
+// authors: wangoo
+// created: 2026-10-19
+// federated identities of external identity providers linked to users
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+const (
+	ProviderWeChat = "wechat"
+	ProviderApple  = "apple"
+	ProviderGoogle = "google"
+)
+
+// FederatedIdentity an identity of an external provider linked to a user
+type FederatedIdentity struct {
+	ID       bson.ObjectId `bson:"_id,omitempty" json:"-"`
+	Provider string        `bson:"provider" json:"provider"`
+
+	// the user id at the provider, e.g. the openid or unionid of wechat, the sub claim of apple and google
+	Subject string `bson:"subject" json:"subject"`
+	UserID  string `bson:"user_id" json:"user_id"`
+
+	// profile claims when linked
+	Email string `bson:"email,omitempty" json:"email,omitempty"`
+	Name  string `bson:"name,omitempty" json:"name,omitempty"`
+
+	LinkedAt    time.Time `bson:"linked_at" json:"linked_at"`
+	LastLoginAt time.Time `bson:"last_login_at,omitempty" json:"last_login_at,omitempty"`
+}
+
+// MgoFederatedStore federated identities of the users of a MgoUserStore, stored in <collection>_federated
+// with a unique index on (provider, subject)
+type MgoFederatedStore struct {
+	users      *MgoUserStore
+	session    *mgo.Session
+	db         string
+	collection string
+}
+
+func NewFederatedStore(users *MgoUserStore) (fs *MgoFederatedStore) {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	fs = &MgoFederatedStore{
+		users:      users,
+		session:    users.session,
+		db:         users.db,
+		collection: users.sideCollection(collectionFederated),
+	}
+
+	c := fs.session.DB(fs.db).C(fs.collection)
+	err := c.EnsureIndex(mgo.Index{
+		Key:    []string{"provider", "subject"},
+		Unique: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = c.EnsureIndex(mgo.Index{
+		Key: []string{"user_id"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// Link link the identity to a user, returns ErrIdentityLinked if linked to any user
+func (fs *MgoFederatedStore) Link(id interface{}, identity *FederatedIdentity) (err error) {
+	if identity == nil || identity.Provider == "" || identity.Subject == "" {
+		return o2x.ErrValueRequired
+	}
+	user, err := fs.users.Find(id)
+	if err != nil {
+		return
+	}
+
+	session := fs.session.Clone()
+	defer session.Close()
+
+	link := *identity
+	link.ID = ""
+	link.UserID = user.GetID()
+	link.LinkedAt = time.Now()
+	err = session.DB(fs.db).C(fs.collection).Insert(&link)
+	if mgo.IsDup(err) {
+		return ErrIdentityLinked
+	}
+	if err != nil {
+		return
+	}
+	glog.Infof("link user %v %v identity %v", link.UserID, link.Provider, link.Subject)
+	return
+}
+
+// Unlink remove the link of the identity to the user
+func (fs *MgoFederatedStore) Unlink(id interface{}, provider, subject string) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := fs.session.Clone()
+	defer session.Close()
+
+	err = session.DB(fs.db).C(fs.collection).Remove(bson.M{"provider": provider, "subject": subject, "user_id": uid})
+	if err == mgo.ErrNotFound {
+		return o2x.ErrNotFound
+	}
+	if err == nil {
+		glog.Infof("unlink user %v %v identity %v", uid, provider, subject)
+	}
+	return
+}
+
+// List the identities linked to a user
+func (fs *MgoFederatedStore) List(id interface{}) (identities []*FederatedIdentity, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := fs.session.Clone()
+	defer session.Close()
+
+	err = session.DB(fs.db).C(fs.collection).Find(bson.M{"user_id": uid}).Sort("linked_at").All(&identities)
+	return
+}
+
+// Find the identity of the provider and subject
+func (fs *MgoFederatedStore) Find(provider, subject string) (identity *FederatedIdentity, err error) {
+	session := fs.session.Clone()
+	defer session.Close()
+
+	identity = &FederatedIdentity{}
+	err = session.DB(fs.db).C(fs.collection).Find(bson.M{"provider": provider, "subject": subject}).One(identity)
+	if err == mgo.ErrNotFound {
+		return nil, o2x.ErrNotFound
+	}
+	return
+}
+
+// FindUser find the user linked to the identity of the provider and subject, and record the login time
+func (fs *MgoFederatedStore) FindUser(provider, subject string) (u o2x.User, err error) {
+	identity, err := fs.Find(provider, subject)
+	if err != nil {
+		return
+	}
+	if u, err = fs.users.Find(identity.UserID); err != nil {
+		return
+	}
+
+	session := fs.session.Clone()
+	defer session.Close()
+	if err := session.DB(fs.db).C(fs.collection).UpdateId(identity.ID, bson.M{"$set": bson.M{"last_login_at": time.Now()}}); err != nil {
+		glog.Warningf("update %v identity %v login time error: %v", provider, subject, err)
+	}
+	return
+}
+
+// Provision find the user linked to the identity, or save the new user and link the identity to it (just-in-time provisioning).
+// If the identity is linked concurrently to another user, the new user is removed and the linked user returned.
+func (fs *MgoFederatedStore) Provision(identity *FederatedIdentity, newUser o2x.User) (u o2x.User, created bool, err error) {
+	if identity == nil || identity.Provider == "" || identity.Subject == "" || newUser == nil {
+		err = o2x.ErrValueRequired
+		return
+	}
+	u, err = fs.FindUser(identity.Provider, identity.Subject)
+	if err != o2x.ErrNotFound {
+		return
+	}
+
+	if err = fs.users.Save(newUser); err != nil {
+		return
+	}
+	err = fs.Link(newUser.GetUserID(), identity)
+	if err != nil {
+		if removeErr := fs.users.Remove(newUser.GetUserID()); removeErr != nil {
+			glog.Errorf("remove provisioned user %v error: %v", newUser.GetUserID(), removeErr)
+		}
+		if err == ErrIdentityLinked {
+			u, err = fs.FindUser(identity.Provider, identity.Subject)
+		}
+		return
+	}
+	glog.Infof("provision user %v for %v identity %v", newUser.GetID(), identity.Provider, identity.Subject)
+	return newUser, true, nil
+}
@@ -0,0 +1,285 @@
+// authors: wangoo
+// created: 2026-10-19
+// failed login throttling and account lockout
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/oauth2.v3/errors"
+	"time"
+)
+
+const (
+	DefaultLockoutMaxAttempts = 5
+	DefaultLockoutBase        = time.Minute
+	DefaultLockoutMax         = 24 * time.Hour
+	DefaultLockoutResetAfter  = 24 * time.Hour
+
+	loginFailureUserPrefix   = "user:"
+	loginFailureMobilePrefix = "mobile:"
+)
+
+// LockoutPolicy lock the login after too many failures,
+// the lockout doubles on each further failure until the max lockout
+type LockoutPolicy struct {
+	// failures allowed before locking
+	MaxAttempts int
+
+	// lockout after MaxAttempts failures
+	BaseLockout time.Duration
+
+	MaxLockout time.Duration
+
+	// failures are forgotten after no failure for this duration, and the lockout ends
+	ResetAfter time.Duration
+}
+
+func DefaultLockoutPolicy() *LockoutPolicy {
+	return &LockoutPolicy{
+		MaxAttempts: DefaultLockoutMaxAttempts,
+		BaseLockout: DefaultLockoutBase,
+		MaxLockout:  DefaultLockoutMax,
+		ResetAfter:  DefaultLockoutResetAfter,
+	}
+}
+
+// copy of the policy with the durations not set defaulted, panics if MaxAttempts is not positive
+func (p *LockoutPolicy) withDefaults() *LockoutPolicy {
+	if p.MaxAttempts <= 0 {
+		panic("lockout max attempts must be positive")
+	}
+	policy := *p
+	if policy.BaseLockout <= 0 {
+		policy.BaseLockout = DefaultLockoutBase
+	}
+	if policy.MaxLockout < policy.BaseLockout {
+		policy.MaxLockout = DefaultLockoutMax
+		if policy.MaxLockout < policy.BaseLockout {
+			policy.MaxLockout = policy.BaseLockout
+		}
+	}
+	if policy.ResetAfter <= 0 {
+		policy.ResetAfter = DefaultLockoutResetAfter
+	}
+	return &policy
+}
+
+// whether failures up to the last one are forgotten at now
+func (p *LockoutPolicy) expired(lastFailedAt, now time.Time) bool {
+	return !lastFailedAt.IsZero() && now.Sub(lastFailedAt) >= p.ResetAfter
+}
+
+// lockout duration after the given number of failures
+func (p *LockoutPolicy) lockout(failures int) time.Duration {
+	if failures < p.MaxAttempts {
+		return 0
+	}
+	d := p.BaseLockout
+	for i := p.MaxAttempts; i < failures && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	if d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+	return d
+}
+
+// failed login counter of a user or an identifier
+type loginFailure struct {
+	ID           string    `bson:"_id"`
+	Failures     int       `bson:"failures"`
+	LastFailedAt time.Time `bson:"last_failed_at"`
+	LockedUntil  time.Time `bson:"locked_until"`
+	ExpiredAt    time.Time `bson:"expired_at"`
+}
+
+// SetLockoutPolicy enable failed login counting, stored in the collection <collection>_login_failure.
+// Durations not set are defaulted, it panics if MaxAttempts is not positive.
+func (us *MgoUserStore) SetLockoutPolicy(policy *LockoutPolicy) {
+	if policy == nil {
+		us.lockout = nil
+		return
+	}
+	us.lockout = policy.withDefaults()
+	us.failureCollection = us.sideCollection(collectionLoginFailure)
+
+	err := us.session.DB(us.db).C(us.failureCollection).EnsureIndex(mgo.Index{
+		Key:         []string{"expired_at"},
+		ExpireAfter: time.Second * 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func loginFailureKeys(id interface{}, mobile string) (keys []string) {
+	if id != nil {
+		if sid, err := o2x.UserIdString(id); err == nil && sid != "" {
+			keys = append(keys, loginFailureUserPrefix+sid)
+		}
+	}
+	if mobile != "" {
+		keys = append(keys, loginFailureMobilePrefix+mobile)
+	}
+	return
+}
+
+// login failure keys of the user and the mobile normalized by the mobile identifier
+func (us *MgoUserStore) loginFailureKeys(id interface{}, mobile string) []string {
+	return loginFailureKeys(id, us.normalizeMobile(mobile))
+}
+
+func (us *MgoUserStore) normalizeMobile(mobile string) string {
+	if idf, err := us.identifier(IdentifierMobile); err == nil {
+		return idf.normalize(mobile)
+	}
+	return NormalizeMobile(mobile)
+}
+
+// LoginLockState whether the login of the user or the mobile is locked, id or mobile can be empty
+func (us *MgoUserStore) LoginLockState(id interface{}, mobile string) (locked bool, until time.Time, err error) {
+	if us.lockout == nil {
+		return
+	}
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.failureCollection)
+
+	var failures []loginFailure
+	keys := us.loginFailureKeys(id, mobile)
+	err = c.Find(bson.M{"_id": bson.M{"$in": keys}}).All(&failures)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, f := range failures {
+		if f.LockedUntil.After(now) && f.LockedUntil.After(until) {
+			locked = true
+			until = f.LockedUntil
+		}
+	}
+	return
+}
+
+// RecordLoginFailure count a login attempt of the user and the mobile, returning the lockout end if locked.
+// It returns ErrAccountLocked without counting while the login is locked,
+// attempts are counted before verifying the credentials and reset by RecordLoginSuccess.
+func (us *MgoUserStore) RecordLoginFailure(id interface{}, mobile string) (until time.Time, err error) {
+	if us.lockout == nil {
+		return
+	}
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.failureCollection)
+
+	for _, key := range us.loginFailureKeys(id, mobile) {
+		var lockedUntil time.Time
+		if lockedUntil, err = us.incLoginFailure(c, key); err != nil {
+			return
+		}
+		if lockedUntil.After(until) {
+			until = lockedUntil
+		}
+	}
+	return
+}
+
+// count a failure of the key, the lock check and the increment are one conditional update of the counter read,
+// so that concurrent attempts retry on the new counter and no more than MaxAttempts are counted before locking
+func (us *MgoUserStore) incLoginFailure(c *mgo.Collection, key string) (until time.Time, err error) {
+	for {
+		now := time.Now()
+		f := loginFailure{}
+		if err = c.FindId(key).One(&f); err != nil && err != mgo.ErrNotFound {
+			return
+		}
+		if f.LockedUntil.After(now) {
+			return f.LockedUntil, ErrAccountLocked
+		}
+
+		failures := f.Failures + 1
+		if us.lockout.expired(f.LastFailedAt, now) {
+			failures = 1
+		}
+		set := bson.M{
+			"failures":       failures,
+			"last_failed_at": now,
+			"locked_until":   time.Time{},
+			"expired_at":     now.Add(us.lockout.ResetAfter),
+		}
+		if d := us.lockout.lockout(failures); d > 0 {
+			until = now.Add(d)
+			glog.Infof("login %v locked until %v after %v failures", key, until, failures)
+			set["locked_until"] = until
+			set["expired_at"] = until.Add(us.lockout.ResetAfter)
+		}
+
+		// a missing counter is inserted, a counter changed concurrently fails the update or the insert
+		_, err = c.Find(bson.M{
+			"_id":            key,
+			"failures":       f.Failures,
+			"last_failed_at": f.LastFailedAt,
+		}).Apply(mgo.Change{Update: bson.M{"$set": set}, Upsert: true}, nil)
+		if err == mgo.ErrNotFound || mgo.IsDup(err) {
+			continue
+		}
+		return
+	}
+}
+
+// RecordLoginSuccess reset the failed login counters of the user and the mobile
+func (us *MgoUserStore) RecordLoginSuccess(id interface{}, mobile string) (err error) {
+	if us.lockout == nil {
+		return
+	}
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.failureCollection)
+
+	_, err = c.RemoveAll(bson.M{"_id": bson.M{"$in": us.loginFailureKeys(id, mobile)}})
+	return
+}
+
+// UnlockLogin admin unlock of the user and the mobile
+func (us *MgoUserStore) UnlockLogin(id interface{}, mobile string) (err error) {
+	glog.Infof("unlock login user %v mobile %v", id, mobile)
+	return us.RecordLoginSuccess(id, mobile)
+}
+
+// PasswordAuthorizationHandler verify the mobile and password, can be set as the server PasswordAuthorizationHandler.
+// It returns ErrAccountLocked while the login is locked, and errors.ErrInvalidGrant for invalid credentials.
+// The attempt is counted before verifying, so concurrent guesses cannot exceed the lockout policy.
+func (us *MgoUserStore) PasswordAuthorizationHandler(mobile, password string) (userID string, err error) {
+	if _, err = us.RecordLoginFailure(nil, mobile); err != nil {
+		return
+	}
+
+	user, err := us.FindMobile(mobile)
+	if err == o2x.ErrNotFound {
+		return "", errors.ErrInvalidGrant
+	}
+	if err != nil {
+		return
+	}
+
+	if _, err = us.RecordLoginFailure(user.GetUserID(), ""); err != nil {
+		return
+	}
+
+	ok, err := us.VerifyPassword(user.GetUserID(), password)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return "", errors.ErrInvalidGrant
+	}
+
+	if err = us.RecordLoginSuccess(user.GetUserID(), mobile); err != nil {
+		glog.Warningf("reset login failures of user %v error: %v", user.GetUserID(), err)
+	}
+	return user.GetID(), nil
+}
@@ -0,0 +1,69 @@
+// authors: wangoo
+// created: 2026-10-19
+// login lockout test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicy(t *testing.T) {
+	p := &LockoutPolicy{
+		MaxAttempts: 3,
+		BaseLockout: time.Minute,
+		MaxLockout:  10 * time.Minute,
+		ResetAfter:  time.Hour,
+	}
+
+	assert.Equal(t, time.Duration(0), p.lockout(1))
+	assert.Equal(t, time.Duration(0), p.lockout(2))
+	assert.Equal(t, time.Minute, p.lockout(3))
+	assert.Equal(t, 2*time.Minute, p.lockout(4))
+	assert.Equal(t, 4*time.Minute, p.lockout(5))
+	assert.Equal(t, 8*time.Minute, p.lockout(6))
+	assert.Equal(t, 10*time.Minute, p.lockout(7))
+	assert.Equal(t, 10*time.Minute, p.lockout(100))
+}
+
+func TestLockoutPolicyDefaults(t *testing.T) {
+	p := (&LockoutPolicy{MaxAttempts: 3}).withDefaults()
+	assert.Equal(t, DefaultLockoutBase, p.BaseLockout)
+	assert.Equal(t, DefaultLockoutMax, p.MaxLockout)
+	assert.Equal(t, DefaultLockoutResetAfter, p.ResetAfter)
+
+	p = (&LockoutPolicy{MaxAttempts: 3, BaseLockout: 48 * time.Hour, ResetAfter: time.Hour}).withDefaults()
+	assert.Equal(t, 48*time.Hour, p.MaxLockout)
+	assert.Equal(t, time.Hour, p.ResetAfter)
+
+	assert.Panics(t, func() { (&LockoutPolicy{}).withDefaults() })
+}
+
+func TestLockoutPolicyExpired(t *testing.T) {
+	p := DefaultLockoutPolicy()
+	now := time.Now()
+	assert.False(t, p.expired(time.Time{}, now))
+	assert.False(t, p.expired(now.Add(-time.Hour), now))
+	assert.True(t, p.expired(now.Add(-p.ResetAfter), now))
+}
+
+func TestLoginFailureKeys(t *testing.T) {
+	assert.Equal(t, []string{"user:u1", "mobile:13344556677"}, loginFailureKeys("u1", "13344556677"))
+	assert.Equal(t, []string{"mobile:13344556677"}, loginFailureKeys(nil, "13344556677"))
+
+	id := bson.ObjectIdHex("5ae6b2005946fa106132365c")
+	assert.Equal(t, 1, len(loginFailureKeys(id, "")))
+}
+
+func TestLoginFailureKeysNormalized(t *testing.T) {
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	assert.Equal(t, []string{"user:u1", "mobile:13344556677"}, us.loginFailureKeys("u1", "133 4455-6677"))
+
+	cfg := DefaultMgoUserCfg().AddIdentifiers(&UniqueIdentifier{Kind: IdentifierMobile, Field: "mobile", Normalize: strings.ToUpper})
+	us = &MgoUserStore{collection: "user", userCfg: cfg}
+	assert.Equal(t, []string{"mobile:ABC"}, us.loginFailureKeys(nil, "abc"))
+}
@@ -0,0 +1,104 @@
+// authors: wangoo
+// created: 2026-10-19
+// user password hashing
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SetPasswordHasher store passwords hashed by the hasher instead of the o2x user password and salt.
+// Existing hashes of other algorithms or parameters, and the legacy o2x passwords,
+// are re-hashed by VerifyPassword on the next successful login.
+func (us *MgoUserStore) SetPasswordHasher(hasher PasswordHasher) {
+	us.hasher = hasher
+}
+
+// set the password hash and remove the legacy password and salt, current is the extra condition for the update
+// and push the optional password history update
+func (us *MgoUserStore) updatePasswordHash(user o2x.User, raw string, current, push bson.M) (err error) {
+	encoded, err := us.hasher.Hash(raw)
+	if err != nil {
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	query := bson.M{"_id": user.GetUserID()}
+	for k, v := range current {
+		query[k] = v
+	}
+	update := bson.M{
+		"$set":   bson.M{us.userCfg.hashName: encoded},
+		"$unset": bson.M{us.userCfg.passwordName: "", us.userCfg.saltName: ""},
+	}
+	if push != nil {
+		update["$push"] = push
+	}
+	err = c.Update(query, update)
+	us.removeUserCache(user.GetUserID())
+	if err == mgo.ErrNotFound {
+		err = o2x.ErrNotFound
+	}
+	return
+}
+
+// VerifyPassword check the raw password of a user,
+// re-hashing and saving it if the stored hash is outdated compared to the password hasher
+func (us *MgoUserStore) VerifyPassword(id interface{}, raw string) (ok bool, err error) {
+	user, err := us.Find(id)
+	if err != nil {
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	doc := bson.M{}
+	err = c.FindId(user.GetUserID()).Select(bson.M{us.userCfg.hashName: 1}).One(&doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = o2x.ErrNotFound
+		}
+		return
+	}
+	encoded, _ := doc[us.userCfg.hashName].(string)
+
+	// legacy o2x password
+	if encoded == "" {
+		ok = user.Match(raw)
+		if ok && us.hasher != nil {
+			us.rehash(user, raw, bson.M{us.userCfg.hashName: bson.M{"$exists": false}})
+		}
+		return
+	}
+
+	ok, err = VerifyPasswordHash(raw, encoded)
+	if err != nil || !ok {
+		return
+	}
+	if us.hasher != nil && us.hasher.NeedsRehash(encoded) {
+		us.rehash(user, raw, bson.M{us.userCfg.hashName: encoded})
+	}
+	return
+}
+
+// rehash only if the hash is not changed meanwhile, failure does not fail the login
+func (us *MgoUserStore) rehash(user o2x.User, raw string, current bson.M) {
+	err := us.updatePasswordHash(user, raw, current, nil)
+	if err != nil && err != o2x.ErrNotFound {
+		glog.Warningf("rehash user %v password error: %v", user.GetUserID(), err)
+		return
+	}
+	if err == nil {
+		glog.Infof("rehash user %v password", user.GetUserID())
+		us.publish(user.GetUserID())
+	}
+}
@@ -0,0 +1,302 @@
+// authors: wangoo
+// created: 2026-10-19
+// roles and groups granting scopes to users
+
+package o2m
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strings"
+)
+
+// effective scopes of a user by the user cache key
+func (us *MgoUserStore) getScopeCache(key string) (scopes map[string]string) {
+	if c, found := us.scopeCache.Get(key); found && c != nil {
+		scopes = c.(map[string]string)
+	}
+	return
+}
+
+// the cached scopes are shared, callers get a copy
+func copyScopes(scopes map[string]string) map[string]string {
+	c := make(map[string]string, len(scopes))
+	for clientID, scope := range scopes {
+		c[clientID] = scope
+	}
+	return c
+}
+
+func (us *MgoUserStore) flushScopeCache() {
+	us.cacheGen.invalidate(us.scopeCache.Flush)
+}
+
+// canonicalScope merge comma separated scopes, removing blanks and duplicates, sorted
+func canonicalScope(scopes ...string) string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, scope := range scopes {
+		for _, s := range strings.Split(scope, ",") {
+			s = strings.TrimSpace(s)
+			if s != "" && !seen[s] {
+				seen[s] = true
+				merged = append(merged, s)
+			}
+		}
+	}
+	sort.Strings(merged)
+	return strings.Join(merged, ",")
+}
+
+// RoleID the id of the role of a client
+func RoleID(clientID, name string) string {
+	return clientID + idSplit + name
+}
+
+// Role a named scope bundle of a client
+type Role struct {
+	// <clientId>__<name>
+	ID       string `bson:"_id" json:"id"`
+	ClientID string `bson:"client_id" json:"client_id"`
+	Name     string `bson:"name" json:"name"`
+
+	// comma separated scopes, as the user scopes
+	Scope string `bson:"scope" json:"scope"`
+}
+
+// Group a set of users granted the roles
+type Group struct {
+	ID    string   `bson:"_id" json:"id"`
+	Users []string `bson:"users" json:"users"`
+
+	// role ids
+	Roles []string `bson:"roles" json:"roles"`
+}
+
+// MgoRoleStore roles and groups of the users of a MgoUserStore,
+// stored in <collection>_role and <collection>_group
+type MgoRoleStore struct {
+	users           *MgoUserStore
+	session         *mgo.Session
+	db              string
+	roleCollection  string
+	groupCollection string
+}
+
+func NewRoleStore(users *MgoUserStore) (rs *MgoRoleStore) {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	rs = &MgoRoleStore{
+		users:           users,
+		session:         users.session,
+		db:              users.db,
+		roleCollection:  users.sideCollection(collectionRole),
+		groupCollection: users.sideCollection(collectionGroup),
+	}
+
+	err := rs.session.DB(rs.db).C(rs.groupCollection).EnsureIndex(mgo.Index{
+		Key: []string{"users"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// any role or group change flushes the effective scopes of all the users
+func (rs *MgoRoleStore) invalidate() {
+	rs.users.flushScopeCache()
+	if rs.users.bus == nil {
+		return
+	}
+	if err := rs.users.bus.Publish(InvalidateScopes, ""); err != nil {
+		glog.Warningf("publish scopes invalidation error: %v", err)
+	}
+}
+
+func (rs *MgoRoleStore) notFound(err error) error {
+	rs.invalidate()
+	if err == mgo.ErrNotFound {
+		return o2x.ErrNotFound
+	}
+	return err
+}
+
+// SaveRole add or replace a role
+func (rs *MgoRoleStore) SaveRole(role *Role) (err error) {
+	if role == nil || role.ClientID == "" || role.Name == "" {
+		return o2x.ErrValueRequired
+	}
+	session := rs.session.Clone()
+	defer session.Close()
+
+	role.ID = RoleID(role.ClientID, role.Name)
+	role.Scope = canonicalScope(role.Scope)
+	_, err = session.DB(rs.db).C(rs.roleCollection).UpsertId(role.ID, role)
+	rs.invalidate()
+	return
+}
+
+// FindRole find a role of a client
+func (rs *MgoRoleStore) FindRole(clientID, name string) (role *Role, err error) {
+	session := rs.session.Clone()
+	defer session.Close()
+
+	role = &Role{}
+	err = session.DB(rs.db).C(rs.roleCollection).FindId(RoleID(clientID, name)).One(role)
+	if err == mgo.ErrNotFound {
+		return nil, o2x.ErrNotFound
+	}
+	return
+}
+
+// RemoveRole remove a role and revoke it from the groups
+func (rs *MgoRoleStore) RemoveRole(clientID, name string) (err error) {
+	session := rs.session.Clone()
+	defer session.Close()
+
+	id := RoleID(clientID, name)
+	if err = session.DB(rs.db).C(rs.roleCollection).RemoveId(id); err != nil {
+		return rs.notFound(err)
+	}
+	_, err = session.DB(rs.db).C(rs.groupCollection).UpdateAll(bson.M{"roles": id}, bson.M{"$pull": bson.M{"roles": id}})
+	rs.invalidate()
+	return
+}
+
+// SaveGroup add or replace a group
+func (rs *MgoRoleStore) SaveGroup(group *Group) (err error) {
+	if group == nil || group.ID == "" {
+		return o2x.ErrValueRequired
+	}
+	session := rs.session.Clone()
+	defer session.Close()
+
+	if group.Users == nil {
+		group.Users = []string{}
+	}
+	if group.Roles == nil {
+		group.Roles = []string{}
+	}
+	_, err = session.DB(rs.db).C(rs.groupCollection).UpsertId(group.ID, group)
+	rs.invalidate()
+	return
+}
+
+// FindGroup find a group
+func (rs *MgoRoleStore) FindGroup(id string) (group *Group, err error) {
+	session := rs.session.Clone()
+	defer session.Close()
+
+	group = &Group{}
+	err = session.DB(rs.db).C(rs.groupCollection).FindId(id).One(group)
+	if err == mgo.ErrNotFound {
+		return nil, o2x.ErrNotFound
+	}
+	return
+}
+
+// RemoveGroup remove a group
+func (rs *MgoRoleStore) RemoveGroup(id string) (err error) {
+	session := rs.session.Clone()
+	defer session.Close()
+
+	return rs.notFound(session.DB(rs.db).C(rs.groupCollection).RemoveId(id))
+}
+
+func (rs *MgoRoleStore) updateGroup(id string, update bson.M) (err error) {
+	session := rs.session.Clone()
+	defer session.Close()
+
+	return rs.notFound(session.DB(rs.db).C(rs.groupCollection).UpdateId(id, update))
+}
+
+// AddMembers add users to a group
+func (rs *MgoRoleStore) AddMembers(id string, userIDs ...string) error {
+	return rs.updateGroup(id, bson.M{"$addToSet": bson.M{"users": bson.M{"$each": userIDs}}})
+}
+
+// RemoveMembers remove users from a group
+func (rs *MgoRoleStore) RemoveMembers(id string, userIDs ...string) error {
+	return rs.updateGroup(id, bson.M{"$pullAll": bson.M{"users": userIDs}})
+}
+
+// AddRoles grant roles to a group
+func (rs *MgoRoleStore) AddRoles(id string, roleIDs ...string) error {
+	return rs.updateGroup(id, bson.M{"$addToSet": bson.M{"roles": bson.M{"$each": roleIDs}}})
+}
+
+// RemoveRoles revoke roles from a group
+func (rs *MgoRoleStore) RemoveRoles(id string, roleIDs ...string) error {
+	return rs.updateGroup(id, bson.M{"$pullAll": bson.M{"roles": roleIDs}})
+}
+
+// UserGroups the groups of a user
+func (rs *MgoRoleStore) UserGroups(id interface{}) (groups []*Group, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := rs.session.Clone()
+	defer session.Close()
+
+	err = session.DB(rs.db).C(rs.groupCollection).Find(bson.M{"users": uid}).All(&groups)
+	return
+}
+
+// EffectiveScopes the scopes of a user by client, merging the direct scopes of the user
+// and the scopes of the roles of the groups of the user, the returned map can be modified
+func (rs *MgoRoleStore) EffectiveScopes(id interface{}) (scopes map[string]string, err error) {
+	user, err := rs.users.Find(id)
+	if err != nil {
+		return
+	}
+	key := fmt.Sprint(user.GetUserID())
+	if cached := rs.users.getScopeCache(key); cached != nil {
+		return copyScopes(cached), nil
+	}
+	// the scopes are not cached if a role, a group or the user changes while loading
+	gen := rs.users.cacheGen.current()
+
+	groups, err := rs.UserGroups(user.GetID())
+	if err != nil {
+		return
+	}
+	var roleIDs []string
+	for _, group := range groups {
+		roleIDs = append(roleIDs, group.Roles...)
+	}
+	var roles []*Role
+	if len(roleIDs) > 0 {
+		session := rs.session.Clone()
+		defer session.Close()
+		err = session.DB(rs.db).C(rs.roleCollection).Find(bson.M{"_id": bson.M{"$in": roleIDs}}).All(&roles)
+		if err != nil {
+			return
+		}
+	}
+
+	scopes = make(map[string]string)
+	for clientID, scope := range user.GetScopes() {
+		scopes[clientID] = canonicalScope(scope)
+	}
+	for _, role := range roles {
+		scopes[role.ClientID] = canonicalScope(scopes[role.ClientID], role.Scope)
+	}
+	rs.users.cacheGen.setIf(gen, func() { rs.users.scopeCache.Set(key, scopes) })
+	return copyScopes(scopes), nil
+}
+
+// EffectiveScope the effective scope of a user on a client
+func (rs *MgoRoleStore) EffectiveScope(id interface{}, clientID string) (scope string, err error) {
+	scopes, err := rs.EffectiveScopes(id)
+	if err != nil {
+		return
+	}
+	return scopes[clientID], nil
+}
@@ -0,0 +1,57 @@
+// authors: wangoo
+// created: 2026-10-19
+// roles and groups test
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCanonicalScope(t *testing.T) {
+	assert.Equal(t, "", canonicalScope())
+	assert.Equal(t, "", canonicalScope("", " , "))
+	assert.Equal(t, "admin,read", canonicalScope("read, admin"))
+	assert.Equal(t, "admin,manage,read", canonicalScope("read,admin", "manage,read", "admin"))
+	assert.Equal(t, "c1__admin", RoleID("c1", "admin"))
+}
+
+func TestScopeCacheEviction(t *testing.T) {
+	us := &MgoUserStore{cache: DefaultCache(), scopeCache: DefaultCache()}
+	us.scopeCache.Set("u1", map[string]string{"c1": "read"})
+	us.scopeCache.Set("u2", map[string]string{"c1": "read"})
+
+	// evicted with the user
+	us.removeUserCache("u1")
+	assert.Nil(t, us.getScopeCache("u1"))
+	assert.Equal(t, "read", us.getScopeCache("u2")["c1"])
+
+	us.flushScopeCache()
+	assert.Nil(t, us.getScopeCache("u2"))
+
+	// loaded before an invalidation, not cached
+	gen := us.cacheGen.current()
+	us.flushScopeCache()
+	assert.False(t, us.cacheGen.setIf(gen, func() { us.scopeCache.Set("u1", map[string]string{"c1": "read"}) }))
+	assert.Nil(t, us.getScopeCache("u1"))
+	gen = us.cacheGen.current()
+	assert.True(t, us.cacheGen.setIf(gen, func() { us.scopeCache.Set("u1", map[string]string{"c1": "read"}) }))
+	assert.Equal(t, "read", us.getScopeCache("u1")["c1"])
+}
+
+func TestEffectiveScopesCopy(t *testing.T) {
+	us := &MgoUserStore{cache: DefaultCache(), scopeCache: DefaultCache()}
+	us.addUserCache(&o2x.SimpleUser{UserID: "u1"})
+	us.scopeCache.Set("u1", map[string]string{"c1": "read"})
+	rs := &MgoRoleStore{users: us}
+
+	scopes, err := rs.EffectiveScopes("u1")
+	assert.Nil(t, err)
+	scopes["c1"] = "admin"
+	scopes["c2"] = "read"
+	scopes, err = rs.EffectiveScopes("u1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"c1": "read"}, scopes)
+}
@@ -0,0 +1,161 @@
+// authors: wangoo
+// created: 2026-10-19
+// per-client user scope management
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// retries of a scope update losing the race against concurrent updates
+const scopeUpdateRetries = 5
+
+func (us *MgoUserStore) scopeField(clientID string) (string, error) {
+	if clientID == "" || strings.ContainsAny(clientID, ".$") {
+		return "", o2x.ErrValueRequired
+	}
+	return us.userCfg.scopesName + "." + clientID, nil
+}
+
+// removeScopes remove scopes from a comma separated scope, the result is canonical
+func removeScopes(scope string, removed ...string) string {
+	drop := make(map[string]bool)
+	for _, s := range strings.Split(canonicalScope(removed...), ",") {
+		drop[s] = true
+	}
+	var kept []string
+	for _, s := range strings.Split(canonicalScope(scope), ",") {
+		if s != "" && !drop[s] {
+			kept = append(kept, s)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+// value of the scope of the client in a user document read as bson.D,
+// sub-documents keep their field order so that the value can be matched as read
+func scopeValue(doc bson.D, scopesName, clientID string) (value interface{}, exists bool) {
+	for _, e := range doc {
+		if e.Name != scopesName {
+			continue
+		}
+		scopes, _ := e.Value.(bson.D)
+		for _, s := range scopes {
+			if s.Name == clientID {
+				return s.Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// updateScope compare and swap the scope of the client, an empty result removes the client scope
+func (us *MgoUserStore) updateScope(id interface{}, clientID string, change func(scope string) string) (err error) {
+	field, err := us.scopeField(clientID)
+	if err != nil {
+		return
+	}
+	user, err := us.Find(id)
+	if err != nil {
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	for i := 0; i < scopeUpdateRetries; i++ {
+		doc := bson.D{}
+		if err = c.FindId(user.GetUserID()).Select(bson.M{field: 1}).One(&doc); err != nil {
+			if err == mgo.ErrNotFound {
+				err = o2x.ErrNotFound
+			}
+			return
+		}
+		value, exists := scopeValue(doc, us.userCfg.scopesName, clientID)
+		// a value which is not a string is replaced
+		current, _ := value.(string)
+		scope := change(current)
+
+		// match the value as read, not the string conversion
+		query := bson.M{"_id": user.GetUserID(), field: value}
+		if !exists {
+			query[field] = bson.M{"$exists": false}
+		}
+		update := bson.M{"$set": bson.M{field: scope}}
+		if scope == "" {
+			update = bson.M{"$unset": bson.M{field: ""}}
+		}
+
+		err = c.Update(query, update)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		glog.Infof("update user %v client %v scope %v", id, clientID, scope)
+		us.removeUserCache(id)
+		us.removeUserCache(user.GetUserID())
+		us.publish(id, user.GetUserID())
+		return
+	}
+	return ErrVersionConflict
+}
+
+// AddScopes add scopes of a client to a user
+func (us *MgoUserStore) AddScopes(id interface{}, clientID string, scopes ...string) error {
+	return us.updateScope(id, clientID, func(scope string) string {
+		return canonicalScope(append([]string{scope}, scopes...)...)
+	})
+}
+
+// RemoveScopes remove scopes of a client from a user, the client scope is removed if none left
+func (us *MgoUserStore) RemoveScopes(id interface{}, clientID string, scopes ...string) error {
+	return us.updateScope(id, clientID, func(scope string) string {
+		return removeScopes(scope, scopes...)
+	})
+}
+
+// ClearClientScopes remove all the scopes of a client from a user
+func (us *MgoUserStore) ClearClientScopes(id interface{}, clientID string) (err error) {
+	field, err := us.scopeField(clientID)
+	if err != nil {
+		return
+	}
+	user, err := us.Find(id)
+	if err != nil {
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	err = c.UpdateId(user.GetUserID(), bson.M{"$unset": bson.M{field: ""}})
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = o2x.ErrNotFound
+		}
+		return
+	}
+	glog.Infof("clear user %v client %v scopes", id, clientID)
+	us.removeUserCache(id)
+	us.removeUserCache(user.GetUserID())
+	us.publish(id, user.GetUserID())
+	return
+}
+
+// UsersWithScope a page of the users directly granted the scope on the client, for access reviews.
+// Scopes granted by roles are not included.
+func (us *MgoUserStore) UsersWithScope(clientID, scope string, limit int, cursor string) (*UserPage, error) {
+	if scope == "" {
+		return nil, o2x.ErrValueRequired
+	}
+	return us.Search(&UserQuery{ClientID: clientID, Scope: scope, Limit: limit, Cursor: cursor})
+}
@@ -0,0 +1,51 @@
+// authors: wangoo
+// created: 2026-10-19
+// user scope test
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestRemoveScopes(t *testing.T) {
+	assert.Equal(t, "read", removeScopes("read,admin", "admin"))
+	assert.Equal(t, "admin,read", removeScopes("read, admin,read", "manage"))
+	assert.Equal(t, "", removeScopes("read,admin", "admin,read"))
+	assert.Equal(t, "", removeScopes("", "admin"))
+}
+
+func TestScopeField(t *testing.T) {
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	field, err := us.scopeField("c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "scopes.c1", field)
+	for _, clientID := range []string{"", "c.1", "$c1"} {
+		_, err = us.scopeField(clientID)
+		assert.Equal(t, o2x.ErrValueRequired, err)
+	}
+}
+
+func TestScopeValue(t *testing.T) {
+	doc := bson.D{{Name: "_id", Value: "u1"}, {Name: "scopes", Value: bson.D{
+		{Name: "c1", Value: "read"},
+		{Name: "c2", Value: nil},
+		{Name: "c3", Value: bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}}},
+	}}}
+	value, exists := scopeValue(doc, "scopes", "c1")
+	assert.True(t, exists)
+	assert.Equal(t, "read", value)
+	value, exists = scopeValue(doc, "scopes", "c2")
+	assert.True(t, exists)
+	assert.Nil(t, value)
+	value, exists = scopeValue(doc, "scopes", "c3")
+	assert.True(t, exists)
+	assert.Equal(t, bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}}, value)
+	_, exists = scopeValue(doc, "scopes", "c4")
+	assert.False(t, exists)
+	_, exists = scopeValue(bson.D{{Name: "scopes", Value: "legacy"}}, "scopes", "c1")
+	assert.False(t, exists)
+}
@@ -0,0 +1,272 @@
+// authors: wangoo
+// created: 2026-10-19
+// admin user search and pagination
+
+package o2m
+
+import (
+	"encoding/base64"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2/bson"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultUserPageSize = 20
+	MaxUserPageSize     = 1000
+)
+
+// UserQuery filters of a user search, zero values are ignored
+type UserQuery struct {
+	MobilePrefix string
+
+	// creation time range [CreatedFrom, CreatedTo), users saved before the creation time was stored are not matched
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+
+	Status string
+
+	// users having the Scope on the client
+	ClientID string
+	Scope    string
+
+	// top level field to sort by, "-" prefix for descending order, _id by default
+	Sort string
+
+	Limit int
+
+	// NextCursor of the previous page
+	Cursor string
+}
+
+// UserPage a page of users
+type UserPage struct {
+	Users []o2x.User
+
+	// cursor of the next page, empty if no more users
+	NextCursor string
+}
+
+// position of the last user of a page
+type userCursor struct {
+	Value interface{} `bson:"v"`
+	ID    interface{} `bson:"id"`
+}
+
+func encodeUserCursor(value, id interface{}) (string, error) {
+	b, err := bson.Marshal(&userCursor{Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeUserCursor(s string) (cursor *userCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor = &userCursor{}
+	if err = bson.Unmarshal(b, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return
+}
+
+// sort field and whether descending
+func (q *UserQuery) sortField() (field string, desc bool) {
+	field = q.Sort
+	if strings.HasPrefix(field, "-") {
+		field, desc = field[1:], true
+	}
+	if field == "" {
+		field = "_id"
+	}
+	return
+}
+
+func (us *MgoUserStore) mobileField() string {
+	if idf, err := us.identifier(IdentifierMobile); err == nil {
+		return idf.Field
+	}
+	return us.userCfg.mobileName
+}
+
+func (us *MgoUserStore) userFilter(q *UserQuery) (filter bson.M, err error) {
+	var and []bson.M
+
+	if q.MobilePrefix != "" {
+		prefix := NormalizeMobile(q.MobilePrefix)
+		and = append(and, bson.M{us.mobileField(): bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)}})
+	}
+
+	created := bson.M{}
+	if !q.CreatedFrom.IsZero() {
+		created["$gte"] = q.CreatedFrom
+	}
+	if !q.CreatedTo.IsZero() {
+		created["$lt"] = q.CreatedTo
+	}
+	if len(created) > 0 {
+		and = append(and, bson.M{us.userCfg.createdName: created})
+	}
+
+	if q.Status == string(UserStatusActive) {
+		// users without status are active
+		and = append(and, bson.M{us.userCfg.statusName: bson.M{"$in": []interface{}{q.Status, nil}}})
+	} else if q.Status != "" {
+		and = append(and, bson.M{us.userCfg.statusName: q.Status})
+	}
+
+	if q.Scope != "" {
+		field, err := us.scopeField(q.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		// scopes set by UpdateScope may have blanks around the commas
+		pattern := `(^|,)\s*` + regexp.QuoteMeta(q.Scope) + `\s*(,|$)`
+		and = append(and, bson.M{field: bson.RegEx{Pattern: pattern}})
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeUserCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		field, desc := q.sortField()
+		and = append(and, cursorFilter(field, desc, cursor))
+	}
+
+	switch len(and) {
+	case 0:
+		filter = bson.M{}
+	case 1:
+		filter = and[0]
+	default:
+		filter = bson.M{"$and": and}
+	}
+	return
+}
+
+// users after the cursor in the sort order.
+// A missing sort field sorts as null, before all values in ascending order and after them in descending order,
+// and null values are not matched by comparison operators.
+func cursorFilter(field string, desc bool, cursor *userCursor) bson.M {
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	if field == "_id" {
+		return idCursorFilter(desc, cursor.ID)
+	}
+	sameValue := idCursorFilter(desc, cursor.ID)
+	sameValue[field] = cursor.Value
+	if cursor.Value == nil {
+		if desc {
+			return sameValue
+		}
+		return bson.M{"$or": []bson.M{{field: bson.M{"$ne": nil}}, sameValue}}
+	}
+	or := []bson.M{{field: bson.M{op: cursor.Value}}, sameValue}
+	if desc {
+		or = append(or, bson.M{field: nil})
+	}
+	return bson.M{"$or": or}
+}
+
+// bson type numbers of the user ids
+const (
+	bsonStringType   = 2
+	bsonObjectIdType = 7
+)
+
+// users with an id after the cursor id in the sort order.
+// Ids are strings or object ids, comparison operators only match values of the same bson type,
+// and strings sort before object ids, so the ids of the other type after the cursor are matched by type.
+func idCursorFilter(desc bool, id interface{}) bson.M {
+	switch id.(type) {
+	case string:
+		if !desc {
+			return bson.M{"$or": []bson.M{{"_id": bson.M{"$gt": id}}, {"_id": bson.M{"$type": bsonObjectIdType}}}}
+		}
+	case bson.ObjectId:
+		if desc {
+			return bson.M{"$or": []bson.M{{"_id": bson.M{"$lt": id}}, {"_id": bson.M{"$type": bsonStringType}}}}
+		}
+	}
+	if desc {
+		return bson.M{"_id": bson.M{"$lt": id}}
+	}
+	return bson.M{"_id": bson.M{"$gt": id}}
+}
+
+// Search users with cursor pagination, the users are decoded into the configured user type
+func (us *MgoUserStore) Search(q *UserQuery) (page *UserPage, err error) {
+	if q == nil {
+		q = &UserQuery{}
+	}
+	field, desc := q.sortField()
+	if field != "_id" && (strings.ContainsAny(field, ".$") || (!bsonFieldNames(us.userCfg.userType)[field] && field != us.userCfg.createdName)) {
+		return nil, ErrInvalidUserField
+	}
+	filter, err := us.userFilter(q)
+	if err != nil {
+		return
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultUserPageSize
+	}
+	if limit > MaxUserPageSize {
+		limit = MaxUserPageSize
+	}
+
+	sort := []string{field, "_id"}
+	if desc {
+		sort = []string{"-" + field, "-_id"}
+	}
+	if field == "_id" {
+		sort = sort[:1]
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	// one more to know whether there is a next page
+	iter := c.Find(filter).Sort(sort...).Limit(limit + 1).Iter()
+	page = &UserPage{}
+	var raw bson.Raw
+	var last bson.M
+	for iter.Next(&raw) {
+		if len(page.Users) == limit {
+			if page.NextCursor, err = encodeUserCursor(last[field], last["_id"]); err != nil {
+				iter.Close()
+				return nil, err
+			}
+			break
+		}
+		user := o2x.NewUser(us.userCfg.userType)
+		if err = raw.Unmarshal(user); err != nil {
+			iter.Close()
+			return nil, err
+		}
+		last = bson.M{}
+		if err = raw.Unmarshal(&last); err != nil {
+			iter.Close()
+			return nil, err
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// List users ordered by id
+func (us *MgoUserStore) List(limit int, cursor string) (*UserPage, error) {
+	return us.Search(&UserQuery{Limit: limit, Cursor: cursor})
+}
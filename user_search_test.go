@@ -0,0 +1,97 @@
+// authors: wangoo
+// created: 2026-10-19
+// user search test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestUserCursor(t *testing.T) {
+	id := bson.ObjectIdHex("5ae6b2005946fa106132365c")
+	created := time.Date(2018, 6, 28, 0, 0, 0, 0, time.UTC)
+
+	s, err := encodeUserCursor(created, id)
+	assert.Nil(t, err)
+	cursor, err := decodeUserCursor(s)
+	assert.Nil(t, err)
+	assert.Equal(t, id, cursor.ID)
+	assert.True(t, created.Equal(cursor.Value.(time.Time)))
+
+	_, err = decodeUserCursor("not a cursor")
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestUserFilter(t *testing.T) {
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+
+	filter, err := us.userFilter(&UserQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{}, filter)
+
+	filter, err = us.userFilter(&UserQuery{MobilePrefix: "133 44"})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"mobile": bson.RegEx{Pattern: "^13344"}}, filter)
+
+	filter, err = us.userFilter(&UserQuery{ClientID: "c1", Scope: "admin", Status: "active"})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$and": []bson.M{
+		{"status": bson.M{"$in": []interface{}{"active", nil}}},
+		{"scopes.c1": bson.RegEx{Pattern: `(^|,)\s*admin\s*(,|$)`}},
+	}}, filter)
+	pattern := regexp.MustCompile(filter["$and"].([]bson.M)[1]["scopes.c1"].(bson.RegEx).Pattern)
+	assert.True(t, pattern.MatchString("read, admin"))
+	assert.True(t, pattern.MatchString("admin ,read"))
+	assert.False(t, pattern.MatchString("read,superadmin"))
+
+	filter, err = us.userFilter(&UserQuery{Status: string(UserStatusSuspended)})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"status": "suspended"}, filter)
+
+	_, err = us.userFilter(&UserQuery{ClientID: "c.1", Scope: "admin"})
+	assert.NotNil(t, err)
+
+	cursor, _ := encodeUserCursor("13344556677", "u1")
+	filter, err = us.userFilter(&UserQuery{Sort: "-mobile", Cursor: cursor})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"mobile": bson.M{"$lt": "13344556677"}},
+		{"mobile": "13344556677", "_id": bson.M{"$lt": "u1"}},
+		{"mobile": nil},
+	}}, filter)
+
+	// the last user of the page has no sort field
+	cursor, _ = encodeUserCursor(nil, "u1")
+	filter, err = us.userFilter(&UserQuery{Sort: "created_at", Cursor: cursor})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"created_at": bson.M{"$ne": nil}},
+		{"created_at": nil, "$or": []bson.M{{"_id": bson.M{"$gt": "u1"}}, {"_id": bson.M{"$type": 7}}}},
+	}}, filter)
+	filter, err = us.userFilter(&UserQuery{Sort: "-created_at", Cursor: cursor})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"created_at": nil, "_id": bson.M{"$lt": "u1"}}, filter)
+}
+
+func TestIdCursorFilter(t *testing.T) {
+	// strings sort before object ids
+	oid := bson.ObjectIdHex("5ae6b2005946fa106132365c")
+	assert.Equal(t, bson.M{"$or": []bson.M{{"_id": bson.M{"$gt": "u1"}}, {"_id": bson.M{"$type": 7}}}}, idCursorFilter(false, "u1"))
+	assert.Equal(t, bson.M{"_id": bson.M{"$lt": "u1"}}, idCursorFilter(true, "u1"))
+	assert.Equal(t, bson.M{"_id": bson.M{"$gt": oid}}, idCursorFilter(false, oid))
+	assert.Equal(t, bson.M{"$or": []bson.M{{"_id": bson.M{"$lt": oid}}, {"_id": bson.M{"$type": 2}}}}, idCursorFilter(true, oid))
+
+	cursor, _ := encodeUserCursor("13344556677", oid)
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	filter, err := us.userFilter(&UserQuery{Sort: "mobile", Cursor: cursor})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"mobile": bson.M{"$gt": "13344556677"}},
+		{"mobile": "13344556677", "_id": bson.M{"$gt": oid}},
+	}}, filter)
+}
@@ -0,0 +1,193 @@
+// authors: wangoo
+// created: 2026-10-19
+// user account status and soft deletion
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// UserStatus status of a user account, users without status are active
+type UserStatus string
+
+const (
+	UserStatusActive              UserStatus = "active"
+	UserStatusSuspended           UserStatus = "suspended"
+	UserStatusPendingVerification UserStatus = "pending_verification"
+	UserStatusDeleted             UserStatus = "deleted"
+
+	// soft deleted users claimed by a purge, they cannot be restored
+	userStatusPurging UserStatus = "purging"
+
+	// soft deleted users can be restored until they are purged after the retention
+	DefaultUserRetention = 30 * 24 * time.Hour
+)
+
+func documentStatus(doc bson.M, statusName string) UserStatus {
+	status, _ := doc[statusName].(string)
+	if status == "" {
+		return UserStatusActive
+	}
+	return UserStatus(status)
+}
+
+// whether users of the status are returned by Find
+func visibleStatus(status UserStatus) bool {
+	return status == UserStatusActive || status == UserStatusPendingVerification
+}
+
+// SetStatus change the status of a user, optionally requiring the current status,
+// then revoke the tokens of the user by the given revokers
+func (us *MgoUserStore) SetStatus(id interface{}, status UserStatus, revokers ...UserTokenRevoker) (err error) {
+	return us.updateStatus(id, "", status, revokers...)
+}
+
+func (us *MgoUserStore) updateStatus(id interface{}, current, status UserStatus, revokers ...UserTokenRevoker) (err error) {
+	switch status {
+	case UserStatusActive, UserStatusSuspended, UserStatusPendingVerification, UserStatusDeleted:
+	default:
+		return ErrInvalidUserStatus
+	}
+	user, _, err := us.FindAny(id)
+	if err != nil {
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	cfg := us.userCfg
+	now := time.Now()
+	query := bson.M{"_id": user.GetUserID()}
+	switch current {
+	case "":
+		// the purge of a user is not interrupted
+		query[cfg.statusName] = bson.M{"$ne": userStatusPurging}
+	case UserStatusActive:
+		query[cfg.statusName] = bson.M{"$in": []interface{}{UserStatusActive, nil}}
+	default:
+		query[cfg.statusName] = current
+	}
+	update := bson.M{"$set": bson.M{cfg.statusName: status, cfg.statusUpdatedName: now}}
+	if status == UserStatusDeleted {
+		update["$set"].(bson.M)[cfg.deletedName] = now
+	} else {
+		update["$unset"] = bson.M{cfg.deletedName: ""}
+	}
+
+	err = c.Update(query, update)
+	us.removeUserCache(id)
+	us.removeUserCache(user.GetUserID())
+	us.publish(id, user.GetUserID())
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = ErrInvalidUserStatus
+		}
+		return
+	}
+	glog.Infof("update user %v status %v", id, status)
+
+	for _, revoker := range revokers {
+		if err = revoker.RemoveByAccountNoClient(user.GetID()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Suspend a user, optionally revoking the tokens of the user
+func (us *MgoUserStore) Suspend(id interface{}, revokers ...UserTokenRevoker) error {
+	return us.SetStatus(id, UserStatusSuspended, revokers...)
+}
+
+// Activate a suspended or pending user
+func (us *MgoUserStore) Activate(id interface{}) error {
+	return us.SetStatus(id, UserStatusActive)
+}
+
+// SoftDelete mark a user deleted, the user and its identifiers are kept until purged,
+// optionally revoking the tokens of the user
+func (us *MgoUserStore) SoftDelete(id interface{}, revokers ...UserTokenRevoker) error {
+	return us.SetStatus(id, UserStatusDeleted, revokers...)
+}
+
+// Restore a soft deleted user which is not purged yet, returns ErrInvalidUserStatus if the user is not deleted
+func (us *MgoUserStore) Restore(id interface{}) error {
+	return us.updateStatus(id, UserStatusDeleted, UserStatusActive)
+}
+
+// PurgeDeleted delete the users soft deleted before the retention by the deleter, returns the purged user ids.
+// A non-positive retention defaults to DefaultUserRetention, interrupted purges are resumed.
+func (us *MgoUserStore) PurgeDeleted(deleter *UserDeleter, retention time.Duration) (purged []string, err error) {
+	if retention <= 0 {
+		retention = DefaultUserRetention
+	}
+	cutoff := time.Now().Add(-retention)
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	var docs []bson.M
+	err = c.Find(bson.M{"$or": us.purgeQuery(cutoff)}).Select(bson.M{"_id": 1}).All(&docs)
+	if err != nil {
+		return
+	}
+
+	for _, doc := range docs {
+		uid, err := o2x.UserIdString(doc["_id"])
+		if err != nil {
+			return purged, err
+		}
+		_, err = deleter.Purge(doc["_id"], cutoff)
+		if err == o2x.ErrNotFound {
+			// restored meanwhile
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged = append(purged, uid)
+	}
+	if len(purged) > 0 {
+		glog.Infof("purge deleted users %v", purged)
+	}
+	return
+}
+
+// users soft deleted before the cutoff, or claimed by an interrupted purge
+func (us *MgoUserStore) purgeQuery(cutoff time.Time) []bson.M {
+	cfg := us.userCfg
+	return []bson.M{
+		{cfg.statusName: UserStatusDeleted, cfg.deletedName: bson.M{"$lt": cutoff}},
+		{cfg.statusName: userStatusPurging},
+	}
+}
+
+// claim the purge of the user by a conditional status update, o2x.ErrNotFound if the user is not to purge
+func (us *MgoUserStore) claimPurge(id interface{}, cutoff time.Time) (err error) {
+	ids := []interface{}{id}
+	if sid, ok := id.(string); ok && bson.IsObjectIdHex(sid) {
+		ids = append(ids, bson.ObjectIdHex(sid))
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	cfg := us.userCfg
+	err = c.Update(bson.M{"_id": bson.M{"$in": ids}, "$or": us.purgeQuery(cutoff)},
+		bson.M{"$set": bson.M{cfg.statusName: userStatusPurging, cfg.statusUpdatedName: time.Now()}})
+	us.removeUserCache(id)
+	us.publish(id)
+	if err == mgo.ErrNotFound {
+		err = o2x.ErrNotFound
+	}
+	return
+}
@@ -0,0 +1,37 @@
+// authors: wangoo
+// created: 2026-10-19
+// user status test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+func TestUserStatus(t *testing.T) {
+	assert.Equal(t, UserStatusActive, documentStatus(bson.M{}, "status"))
+	assert.Equal(t, UserStatusActive, documentStatus(bson.M{"status": ""}, "status"))
+	assert.Equal(t, UserStatusSuspended, documentStatus(bson.M{"status": "suspended"}, "status"))
+	assert.Equal(t, UserStatusDeleted, documentStatus(bson.M{"state": "active", "status": "deleted"}, "status"))
+
+	assert.True(t, visibleStatus(UserStatusActive))
+	assert.True(t, visibleStatus(UserStatusPendingVerification))
+	assert.False(t, visibleStatus(UserStatusSuspended))
+	assert.False(t, visibleStatus(UserStatusDeleted))
+
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	assert.Equal(t, ErrInvalidUserStatus, us.SetStatus("u1", "closed"))
+}
+
+func TestPurgeQuery(t *testing.T) {
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	cutoff := time.Now()
+	assert.Equal(t, []bson.M{
+		{"status": UserStatusDeleted, "deleted_at": bson.M{"$lt": cutoff}},
+		{"status": userStatusPurging},
+	}, us.purgeQuery(cutoff))
+	assert.False(t, visibleStatus(userStatusPurging))
+}
@@ -10,6 +10,9 @@ import (
 	"gopkg.in/mgo.v2/bson"
 	"github.com/soundbus-technologies/o2x"
 	"fmt"
+	"time"
+	"sync"
+	"reflect"
 )
 
 const (
@@ -71,9 +74,7 @@ func TestMgoUserStore(t *testing.T) {
 	}
 	err = us.Save(user2)
 	fmt.Println(err)
-	if err == nil {
-		assert.Fail(t, "should throw mobile duplicated error")
-	}
+	assert.Equal(t, ErrDuplicateMobile, err)
 	//-------------------------------add user with different mobile
 	us.Remove("user3")
 	user3 := &o2x.SimpleUser{
@@ -85,6 +86,154 @@ func TestMgoUserStore(t *testing.T) {
 		assert.Fail(t, err.Error())
 	}
 
+	//-------------------------------change mobile
+	mobile3 := "13344556600"
+	err = us.UpdateMobile("user3", mobile1)
+	assert.Equal(t, ErrDuplicateMobile, err)
+	err = us.UpdateMobile("user3", mobile3)
+	assert.Nil(t, err)
+	user, err = us.FindMobile(mobile3)
+	assert.Nil(t, err)
+	assert.Equal(t, "user3", user.GetID())
+	user3.UserID = "user6"
+	user3.Mobile = mobile2
+	us.Remove("user6")
+	err = us.Save(user3)
+	assert.Nil(t, err)
+	us.Remove("user6")
+
+	//-------------------------------add user with duplicated id, the mobile lock should be released
+	mobile4 := "13344556699"
+	us.Remove("user4")
+	us.Remove("user5")
+	user4 := &o2x.SimpleUser{
+		UserID: "user4",
+	}
+	err = us.Save(user4)
+	assert.Nil(t, err)
+	user4.Mobile = mobile4
+	err = us.Save(user4)
+	assert.Equal(t, ErrDuplicateUserID, err)
+	user5 := &o2x.SimpleUser{
+		UserID: "user5",
+		Mobile: mobile4,
+	}
+	err = us.Save(user5)
+	assert.Nil(t, err)
+	us.Remove("user4")
+	us.Remove("user5")
+
+	//-------------------------------legacy claims
+	mobile6 := "133-4455-6622"
+	us.Remove("user10")
+	us.Remove("user11")
+	err = us.Save(&o2x.SimpleUser{UserID: "user10"})
+	assert.Nil(t, err)
+	err = mgoSession.DB(mgoDatabase).C("user_mobile").Insert(bson.M{"_id": "user10", "mobile": mobile6})
+	assert.Nil(t, err)
+	user, err = us.FindMobile(mobile6)
+	assert.Nil(t, err)
+	assert.Equal(t, "user10", user.GetID())
+	user, err = us.FindMobile("13344556622")
+	assert.Nil(t, err)
+	assert.Equal(t, "user10", user.GetID())
+	us.Remove("user10")
+
+	mobile7 := "13344556633"
+	err = us.Save(&o2x.SimpleUser{UserID: "user11"})
+	assert.Nil(t, err)
+	err = mgoSession.DB(mgoDatabase).C("user").UpdateId("user11", bson.M{"$set": bson.M{"mobile": mobile7}})
+	assert.Nil(t, err)
+	user, err = us.FindMobile(mobile7)
+	assert.Nil(t, err)
+	assert.Equal(t, "user11", user.GetID())
+	n, err := mgoSession.DB(mgoDatabase).C("user_mobile").Find(bson.M{"mobile": mobile7}).Count()
+	assert.Equal(t, 1, n)
+	us.Remove("user11")
+
+	//-------------------------------cascading deletion
+	mobile5 := "13344556611"
+	user7 := &o2x.SimpleUser{
+		UserID: "user7",
+		Mobile: mobile5,
+	}
+	deleter := NewUserDeleter(us, nil, nil, nil)
+	err = us.Save(user7)
+	assert.Nil(t, err)
+	us.SetLockoutPolicy(&LockoutPolicy{MaxAttempts: 3})
+	_, err = us.RecordLoginFailure("user7", mobile5)
+	assert.Nil(t, err)
+	report, err := deleter.Delete("user7")
+	assert.Nil(t, err)
+	assert.True(t, report.Finished())
+	assert.True(t, report.User)
+	assert.Equal(t, 1, report.Identifiers)
+	assert.Equal(t, 2, report.Credentials)
+	n, err = mgoSession.DB(mgoDatabase).C("user_login_failure").Find(bson.M{"_id": bson.M{"$in": []string{"user:user7", "mobile:" + mobile5}}}).Count()
+	assert.Equal(t, 0, n)
+	us.SetLockoutPolicy(nil)
+	_, err = us.FindMobile(mobile5)
+	assert.Equal(t, o2x.ErrNotFound, err)
+	report, err = deleter.Delete("user7")
+	assert.Nil(t, err)
+	assert.False(t, report.User)
+
+	//-------------------------------federated identity
+	fs := NewFederatedStore(us)
+	identity := &FederatedIdentity{Provider: ProviderWeChat, Subject: "openid1"}
+	fs.Unlink("user8", ProviderWeChat, "openid1")
+	us.Remove("user8")
+	user8 := &o2x.SimpleUser{UserID: "user8"}
+	user, created, err := fs.Provision(identity, user8)
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "user8", user.GetID())
+	user, created, err = fs.Provision(identity, &o2x.SimpleUser{UserID: "user9"})
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "user8", user.GetID())
+	err = fs.Link("user3", identity)
+	assert.Equal(t, ErrIdentityLinked, err)
+	identities, err := fs.List("user8")
+	assert.Len(t, identities, 1)
+	err = fs.Unlink("user8", ProviderWeChat, "openid1")
+	assert.Nil(t, err)
+	_, err = fs.FindUser(ProviderWeChat, "openid1")
+	assert.Equal(t, o2x.ErrNotFound, err)
+	us.Remove("user8")
+
+	//-------------------------------status
+	err = us.Save(user7)
+	assert.Nil(t, err)
+	err = us.Suspend("user7")
+	assert.Nil(t, err)
+	_, err = us.FindMobile(mobile5)
+	assert.Equal(t, ErrUserSuspended, err)
+	err = us.Restore("user7")
+	assert.Equal(t, ErrInvalidUserStatus, err)
+	err = us.SoftDelete("user7")
+	assert.Nil(t, err)
+	_, err = us.Find("user7")
+	assert.Equal(t, o2x.ErrNotFound, err)
+	_, status, err := us.FindAny("user7")
+	assert.Equal(t, UserStatusDeleted, status)
+	err = us.Restore("user7")
+	assert.Nil(t, err)
+	_, err = us.Find("user7")
+	assert.Nil(t, err)
+	// a restored user is not purged
+	_, err = deleter.Purge("user7", time.Now())
+	assert.Equal(t, o2x.ErrNotFound, err)
+	err = us.SoftDelete("user7")
+	assert.Nil(t, err)
+	purged, err := us.PurgeDeleted(deleter, 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, purged, "user7")
+	time.Sleep(10 * time.Millisecond)
+	purged, err = us.PurgeDeleted(deleter, time.Millisecond)
+	assert.Nil(t, err)
+	assert.Contains(t, purged, "user7")
+
 	//-------------------------------
 
 	us.UpdatePwd(id, pass)
@@ -103,6 +252,199 @@ func TestMgoUserStore(t *testing.T) {
 	assert.Equal(t, "manage,admin", user.GetScopes()["c1"])
 	assert.Equal(t, "operate,view", user.GetScopes()["c2"])
 
+	//-------------------------------password policy
+	policy := DefaultPasswordPolicy()
+	policy.History = 2
+	us.SetPasswordPolicy(policy)
+	err = us.UpdatePwd(id, "short")
+	assert.Equal(t, []PasswordViolation{PasswordTooShort, PasswordMissingDigit}, err.(*PasswordPolicyError).Violations)
+	err = us.UpdatePwd(id, "secret123")
+	assert.Nil(t, err)
+	err = us.UpdatePwd(id, "secret123")
+	assert.Equal(t, []PasswordViolation{PasswordReused}, err.(*PasswordPolicyError).Violations)
+	us.SetPasswordPolicy(nil)
+
+	//-------------------------------verification
+	vs := NewVerificationStore(us, nil)
+	code, err := vs.IssueVerification(id, IdentifierMobile)
+	assert.Nil(t, err)
+	err = vs.Verify(id, IdentifierMobile, "wrong")
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+	err = vs.Verify(id, IdentifierMobile, code)
+	assert.Nil(t, err)
+	verified, err := vs.Verified(id, IdentifierMobile)
+	assert.True(t, verified)
+	err = vs.Verify(id, IdentifierMobile, code)
+	assert.Equal(t, ErrInvalidVerificationToken, err)
+
+	// reissuing does not reset the wrong attempts
+	vs.SetMaxAttempts(2)
+	code, err = vs.IssueVerification(id, IdentifierMobile)
+	assert.Nil(t, err)
+	vs.Verify(id, IdentifierMobile, "wrong")
+	vs.Verify(id, IdentifierMobile, "wrong")
+	code, err = vs.IssueVerification(id, IdentifierMobile)
+	assert.Nil(t, err)
+	err = vs.Verify(id, IdentifierMobile, code)
+	assert.Equal(t, ErrVerificationAttemptsExceeded, err)
+	vs.Revoke(id, VerifyPurpose(IdentifierMobile))
+	vs.SetMaxAttempts(DefaultVerificationMaxAttempts)
+
+	token, err := vs.IssuePasswordReset(id, false)
+	assert.Nil(t, err)
+	err = vs.ResetPassword(id, token, "reset1234")
+	assert.Nil(t, err)
+	ok, err := us.VerifyPassword(id, "reset1234")
+	assert.True(t, ok)
+
+	//-------------------------------scope management
+	err = us.AddScopes(id, "c4", "view", "audit")
+	assert.Nil(t, err)
+	err = us.AddScopes(id, "c4", "view,manage")
+	assert.Nil(t, err)
+	user, err = us.Find(id)
+	assert.Equal(t, "audit,manage,view", user.GetScopes()["c4"])
+	page, err := us.UsersWithScope("c4", "manage", 10, "")
+	assert.Nil(t, err)
+	assert.Len(t, page.Users, 1)
+	err = us.RemoveScopes(id, "c4", "manage", "view")
+	assert.Nil(t, err)
+	user, err = us.Find(id)
+	assert.Equal(t, "audit", user.GetScopes()["c4"])
+	err = us.ClearClientScopes(id, "c4")
+	assert.Nil(t, err)
+	user, err = us.Find(id)
+	_, ok = user.GetScopes()["c4"]
+	assert.False(t, ok)
+
+	//-------------------------------totp lockout
+	ts := NewTOTPStore(us, []byte("0123456789abcdef0123456789abcdef"), "o2m")
+	ts.SetLockoutPolicy(&LockoutPolicy{MaxAttempts: 3})
+	ts.Disable(id)
+	enrollment, err := ts.Enroll(id)
+	assert.Nil(t, err)
+	secret, _ := totpEncoding.DecodeString(enrollment.Secret)
+	step := time.Now().Unix() / DefaultTOTPPeriod
+	err = ts.Confirm(id, totpCode(secret, step-1, DefaultTOTPDigits))
+	assert.Nil(t, err)
+	wrong := "000000"
+	if wrong == totpCode(secret, step, DefaultTOTPDigits) {
+		wrong = "000001"
+	}
+	assert.Equal(t, ErrInvalidTOTPCode, ts.Verify(id, wrong))
+	assert.Equal(t, ErrInvalidTOTPCode, ts.Verify(id, "invalid-recovery"))
+	assert.Equal(t, ErrAccountLocked, ts.Verify(id, wrong))
+	// locked even with a valid code
+	assert.Equal(t, ErrAccountLocked, ts.Verify(id, totpCode(secret, step, DefaultTOTPDigits)))
+	ts.Disable(id)
+
+	//-------------------------------login lockout
+	us.SetLockoutPolicy(&LockoutPolicy{MaxAttempts: 3})
+	us.UnlockLogin(id, mobile1)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := us.RecordLoginFailure(nil, "133-4455-6677"); err == nil {
+				mu.Lock()
+				counted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, counted)
+	locked, _, err := us.LoginLockState(nil, mobile1)
+	assert.Nil(t, err)
+	assert.True(t, locked)
+	_, err = us.PasswordAuthorizationHandler(mobile1, pass)
+	assert.Equal(t, ErrAccountLocked, err)
+	us.UnlockLogin(nil, "133 4455 6677")
+	locked, _, err = us.LoginLockState(nil, mobile1)
+	assert.False(t, locked)
+	us.SetLockoutPolicy(nil)
+
+	//-------------------------------roles and groups
+	rs := NewRoleStore(us)
+	err = rs.SaveRole(&Role{ClientID: "c1", Name: "auditor", Scope: "audit,view"})
+	assert.Nil(t, err)
+	err = rs.SaveGroup(&Group{ID: "staff", Users: []string{id}, Roles: []string{RoleID("c1", "auditor")}})
+	assert.Nil(t, err)
+	scope, err := rs.EffectiveScope(id, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin,audit,manage,view", scope)
+	err = rs.RemoveMembers("staff", id)
+	assert.Nil(t, err)
+	scope, err = rs.EffectiveScope(id, "c1")
+	assert.Equal(t, "admin,manage", scope)
+	rs.RemoveGroup("staff")
+	rs.RemoveRole("c1", "auditor")
+
+	//-------------------------------export
+	exporter := NewUserExporter(us, nil, nil, nil)
+	export, err := exporter.Export(id)
+	assert.Nil(t, err)
+	assert.Equal(t, mobile1, export.Identifiers[IdentifierMobile])
+	_, ok = export.User["password"]
+	assert.False(t, ok)
+
+	//-------------------------------paging mixed id types
+	pagingStore := NewUserStore(mgoSession, mgoDatabase, "user_paging", DefaultMgoUserCfg())
+	pagingIds := []interface{}{"u1", "u2", bson.ObjectIdHex("5ae6b2005946fa1061323600"), bson.ObjectIdHex("5ae6b2005946fa1061323601")}
+	for i, pid := range pagingIds {
+		pagingStore.Remove(pid)
+		err = pagingStore.Save(&o2x.SimpleUser{UserID: pid, Mobile: fmt.Sprintf("1990000000%d", i)})
+		assert.Nil(t, err)
+	}
+	for _, sort := range []string{"_id", "-_id"} {
+		var paged []interface{}
+		cursor := ""
+		for {
+			page, err := pagingStore.Search(&UserQuery{Sort: sort, Limit: 1, Cursor: cursor})
+			assert.Nil(t, err)
+			for _, u := range page.Users {
+				paged = append(paged, u.GetUserID())
+			}
+			if cursor = page.NextCursor; cursor == "" || len(paged) > len(pagingIds) {
+				break
+			}
+		}
+		// strings sort before object ids
+		if sort == "-_id" {
+			assert.Equal(t, []interface{}{pagingIds[3], pagingIds[2], pagingIds[1], pagingIds[0]}, paged)
+		} else {
+			assert.Equal(t, pagingIds, paged)
+		}
+	}
+	for _, pid := range pagingIds {
+		pagingStore.Remove(pid)
+	}
+
+	//-------------------------------update with version
+	_, version, err := us.FindVersion(id)
+	assert.Nil(t, err)
+	err = us.Update(id, &UserPatch{Version: version, Set: bson.M{"scopes.c3": "view"}})
+	assert.Equal(t, ErrInvalidUserField, err)
+
+	profileCfg := DefaultMgoUserCfg()
+	profileCfg.userType = reflect.TypeOf(&testUser{})
+	ps := NewUserStore(mgoSession, mgoDatabase, "user_profile", profileCfg)
+	ps.Remove(id)
+	err = ps.Save(&testUser{SimpleUser: o2x.SimpleUser{UserID: bson.ObjectIdHex(id), Mobile: mobile1}})
+	assert.Nil(t, err)
+	_, version, err = ps.FindVersion(id)
+	assert.Nil(t, err)
+	err = ps.Update(id, &UserPatch{Version: version, Set: bson.M{"profile.nickname": "wangoo"}})
+	assert.Nil(t, err)
+	err = ps.Update(id, &UserPatch{Version: version, Set: bson.M{"profile.nickname": "oo"}})
+	assert.Equal(t, ErrVersionConflict, err)
+	user, err = ps.Find(id)
+	assert.Equal(t, "wangoo", user.(*testUser).Profile.Nickname)
+	ps.Remove(id)
+
 	err = us.Remove(id)
 	if err != nil {
 		assert.Fail(t, err.Error())
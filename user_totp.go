@@ -0,0 +1,442 @@
+// authors: wangoo
+// created: 2026-10-19
+// TOTP (RFC 6238) two-factor authentication
+
+package o2m
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTOTPPeriod        = 30
+	DefaultTOTPDigits        = 6
+	DefaultTOTPSkew          = 1
+	DefaultTOTPRecoveryCodes = 10
+
+	totpSecretLen    = 20
+	recoveryCodeLen  = 10
+	recoveryCodeHalf = recoveryCodeLen / 2
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totp settings of a user
+type userTOTP struct {
+	UserID string `bson:"_id"`
+
+	// nonce and encrypted secret
+	Secret  []byte `bson:"secret"`
+	Enabled bool   `bson:"enabled"`
+
+	// last accepted time step, a code of this step or before cannot be used again
+	LastStep int64 `bson:"last_step"`
+
+	// sha256 hex of the unused recovery codes
+	RecoveryCodes []string  `bson:"recovery_codes"`
+	CreatedAt     time.Time `bson:"created_at"`
+	EnabledAt     time.Time `bson:"enabled_at,omitempty"`
+
+	// failed verifications, locking the verification by the lockout policy
+	Failures     int       `bson:"failures,omitempty"`
+	LastFailedAt time.Time `bson:"last_failed_at,omitempty"`
+	LockedUntil  time.Time `bson:"locked_until,omitempty"`
+}
+
+// TOTPEnrollment shown to the user once, to add the secret to an authenticator app
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MgoTOTPStore totp of the users of a MgoUserStore, stored in the collection <collection>_totp
+type MgoTOTPStore struct {
+	users      *MgoUserStore
+	session    *mgo.Session
+	db         string
+	collection string
+	aead       cipher.AEAD
+	issuer     string
+	skew       int
+	lockout    *LockoutPolicy
+}
+
+// NewTOTPStore create a totp store, key is the AES key (16, 24 or 32 bytes) encrypting the secrets,
+// issuer is displayed by authenticator apps
+func NewTOTPStore(users *MgoUserStore, key []byte, issuer string) *MgoTOTPStore {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &MgoTOTPStore{
+		users:      users,
+		session:    users.session,
+		db:         users.db,
+		collection: users.sideCollection(collectionTOTP),
+		aead:       aead,
+		issuer:     issuer,
+		skew:       DefaultTOTPSkew,
+		lockout:    DefaultLockoutPolicy(),
+	}
+}
+
+// SetSkew set the number of time steps accepted before and after the current one
+func (ts *MgoTOTPStore) SetSkew(skew int) {
+	ts.skew = skew
+}
+
+// SetLockoutPolicy lock the verification of a user after too many failed codes, DefaultLockoutPolicy by default.
+// Durations not set are defaulted, it panics if MaxAttempts is not positive.
+func (ts *MgoTOTPStore) SetLockoutPolicy(policy *LockoutPolicy) {
+	ts.lockout = policy.withDefaults()
+}
+
+// count a failed verification, returns ErrAccountLocked if the verification gets locked
+func (ts *MgoTOTPStore) verifyFailed(c *mgo.Collection, t *userTOTP, now time.Time) error {
+	if ts.lockout.expired(t.LastFailedAt, now) {
+		// the previous failures are forgotten, unless counted again concurrently
+		err := c.Update(bson.M{"_id": t.UserID, "last_failed_at": t.LastFailedAt}, bson.M{"$set": bson.M{"failures": 0}})
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	f := &userTOTP{}
+	_, err := c.FindId(t.UserID).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failed_at": now}},
+		ReturnNew: true,
+	}, f)
+	if err != nil {
+		return err
+	}
+	d := ts.lockout.lockout(f.Failures)
+	if d == 0 {
+		return ErrInvalidTOTPCode
+	}
+	lockedUntil := now.Add(d)
+	glog.Infof("user %v totp locked until %v after %v failures", t.UserID, lockedUntil, f.Failures)
+	if err = c.UpdateId(t.UserID, bson.M{"$set": bson.M{"locked_until": lockedUntil}}); err != nil {
+		return err
+	}
+	return ErrAccountLocked
+}
+
+func (ts *MgoTOTPStore) encrypt(secret []byte, userID string) ([]byte, error) {
+	nonce := make([]byte, ts.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// the user id is authenticated so a secret cannot be moved to another user
+	return ts.aead.Seal(nonce, nonce, secret, []byte(userID)), nil
+}
+
+func (ts *MgoTOTPStore) decrypt(data []byte, userID string) ([]byte, error) {
+	n := ts.aead.NonceSize()
+	if len(data) < n {
+		return nil, ErrInvalidTOTPCode
+	}
+	return ts.aead.Open(nil, data[:n], data[n:], []byte(userID))
+}
+
+// totpCode HOTP (RFC 4226) of a time step with HMAC-SHA1
+func totpCode(secret []byte, step int64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+// the time step matching the code within the skew, the latest first
+func matchTOTP(secret []byte, code string, now time.Time, skew int) (step int64, ok bool) {
+	current := now.Unix() / DefaultTOTPPeriod
+	for i := skew; i >= -skew; i-- {
+		s := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, s, DefaultTOTPDigits)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// recovery codes like "abcde-fghij" and their hashes
+func newRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeLen)
+		if _, err = rand.Read(b); err != nil {
+			return
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:recoveryCodeLen]
+		codes = append(codes, code[:recoveryCodeHalf]+"-"+code[recoveryCodeHalf:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return
+}
+
+func (ts *MgoTOTPStore) uri(account string, secret []byte) string {
+	label := url.PathEscape(account)
+	if ts.issuer != "" {
+		label = url.PathEscape(ts.issuer) + ":" + label
+	}
+	v := url.Values{}
+	v.Set("secret", totpEncoding.EncodeToString(secret))
+	if ts.issuer != "" {
+		v.Set("issuer", ts.issuer)
+	}
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(DefaultTOTPDigits))
+	v.Set("period", fmt.Sprint(DefaultTOTPPeriod))
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+func (ts *MgoTOTPStore) find(c *mgo.Collection, userID string) (t *userTOTP, err error) {
+	t = &userTOTP{}
+	err = c.FindId(userID).One(t)
+	if err == mgo.ErrNotFound {
+		err = ErrTOTPNotEnabled
+	}
+	return
+}
+
+// Enroll generate a new secret and recovery codes for a user, the totp is enabled by Confirm
+func (ts *MgoTOTPStore) Enroll(id interface{}) (enrollment *TOTPEnrollment, err error) {
+	user, err := ts.users.Find(id)
+	if err != nil {
+		return
+	}
+	uid := user.GetID()
+
+	secret := make([]byte, totpSecretLen)
+	if _, err = rand.Read(secret); err != nil {
+		return
+	}
+	encrypted, err := ts.encrypt(secret, uid)
+	if err != nil {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes(DefaultTOTPRecoveryCodes)
+	if err != nil {
+		return
+	}
+
+	session := ts.session.Clone()
+	defer session.Close()
+	c := session.DB(ts.db).C(ts.collection)
+
+	// replace a pending enrollment, but never an enabled one
+	_, err = c.Upsert(bson.M{"_id": uid, "enabled": false}, &userTOTP{
+		UserID:        uid,
+		Secret:        encrypted,
+		RecoveryCodes: hashes,
+		CreatedAt:     time.Now(),
+	})
+	if mgo.IsDup(err) {
+		err = ErrTOTPAlreadyEnabled
+	}
+	if err != nil {
+		return
+	}
+	glog.Infof("enroll user %v totp", uid)
+
+	account := user.GetMobile()
+	if account == "" {
+		account = uid
+	}
+	enrollment = &TOTPEnrollment{
+		Secret:        totpEncoding.EncodeToString(secret),
+		URI:           ts.uri(account, secret),
+		RecoveryCodes: codes,
+	}
+	return
+}
+
+// Confirm enable the enrolled totp after verifying a code from the authenticator app
+func (ts *MgoTOTPStore) Confirm(id interface{}, code string) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ts.session.Clone()
+	defer session.Close()
+	c := session.DB(ts.db).C(ts.collection)
+
+	t, err := ts.find(c, uid)
+	if err != nil {
+		return
+	}
+	if t.Enabled {
+		return ErrTOTPAlreadyEnabled
+	}
+	secret, err := ts.decrypt(t.Secret, uid)
+	if err != nil {
+		return
+	}
+	step, ok := matchTOTP(secret, code, time.Now(), ts.skew)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	err = c.Update(bson.M{"_id": uid, "enabled": false}, bson.M{"$set": bson.M{
+		"enabled":    true,
+		"enabled_at": time.Now(),
+		"last_step":  step,
+	}})
+	if err == mgo.ErrNotFound {
+		err = ErrTOTPAlreadyEnabled
+	}
+	if err == nil {
+		glog.Infof("enable user %v totp", uid)
+	}
+	return
+}
+
+// Disable remove the totp of a user
+func (ts *MgoTOTPStore) Disable(id interface{}) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ts.session.Clone()
+	defer session.Close()
+
+	err = session.DB(ts.db).C(ts.collection).RemoveId(uid)
+	if err == mgo.ErrNotFound {
+		err = ErrTOTPNotEnabled
+	}
+	if err == nil {
+		glog.Infof("disable user %v totp", uid)
+	}
+	return
+}
+
+// Enabled whether the user has to pass the totp verification
+func (ts *MgoTOTPStore) Enabled(id interface{}) (enabled bool, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ts.session.Clone()
+	defer session.Close()
+
+	n, err := session.DB(ts.db).C(ts.collection).Find(bson.M{"_id": uid, "enabled": true}).Count()
+	return n > 0, err
+}
+
+// Verify a totp code or a recovery code of a user, each time step code and each recovery code can be used only once.
+// Failed codes are counted, it returns ErrAccountLocked while the verification is locked by the lockout policy.
+func (ts *MgoTOTPStore) Verify(id interface{}, code string) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ts.session.Clone()
+	defer session.Close()
+	c := session.DB(ts.db).C(ts.collection)
+
+	t, err := ts.find(c, uid)
+	if err != nil {
+		return
+	}
+	if !t.Enabled {
+		return ErrTOTPNotEnabled
+	}
+	now := time.Now()
+	if t.LockedUntil.After(now) {
+		return ErrAccountLocked
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == DefaultTOTPDigits {
+		secret, err := ts.decrypt(t.Secret, uid)
+		if err != nil {
+			return err
+		}
+		step, ok := matchTOTP(secret, code, now, ts.skew)
+		if !ok {
+			return ts.verifyFailed(c, t, now)
+		}
+		err = c.Update(bson.M{"_id": uid, "last_step": bson.M{"$lt": step}}, bson.M{
+			"$set":   bson.M{"last_step": step},
+			"$unset": bson.M{"failures": "", "last_failed_at": "", "locked_until": ""},
+		})
+		if err == mgo.ErrNotFound {
+			return ErrTOTPCodeReused
+		}
+		return err
+	}
+
+	hash := hashRecoveryCode(code)
+	err = c.Update(bson.M{"_id": uid, "recovery_codes": hash}, bson.M{
+		"$pull":  bson.M{"recovery_codes": hash},
+		"$unset": bson.M{"failures": "", "last_failed_at": "", "locked_until": ""},
+	})
+	if err == mgo.ErrNotFound {
+		return ts.verifyFailed(c, t, now)
+	}
+	if err == nil {
+		glog.Infof("user %v used a totp recovery code", uid)
+	}
+	return
+}
+
+// RegenerateRecoveryCodes replace the recovery codes of an enabled totp
+func (ts *MgoTOTPStore) RegenerateRecoveryCodes(id interface{}) (codes []string, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes(DefaultTOTPRecoveryCodes)
+	if err != nil {
+		return
+	}
+	session := ts.session.Clone()
+	defer session.Close()
+
+	err = session.DB(ts.db).C(ts.collection).Update(bson.M{"_id": uid, "enabled": true},
+		bson.M{"$set": bson.M{"recovery_codes": hashes}})
+	if err == mgo.ErrNotFound {
+		err = ErrTOTPNotEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
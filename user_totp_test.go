@@ -0,0 +1,51 @@
+// authors: wangoo
+// created: 2026-10-19
+// totp test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1
+	secret := []byte("12345678901234567890")
+	assert.Equal(t, "94287082", totpCode(secret, 59/30, 8))
+	assert.Equal(t, "07081804", totpCode(secret, 1111111109/30, 8))
+	assert.Equal(t, "89005924", totpCode(secret, 1234567890/30, 8))
+	assert.Equal(t, "287082", totpCode(secret, 59/30, 6))
+
+	now := time.Unix(1234567890, 0)
+	step, ok := matchTOTP(secret, totpCode(secret, now.Unix()/30-1, 6), now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30-1, step)
+	_, ok = matchTOTP(secret, totpCode(secret, now.Unix()/30-2, 6), now, 1)
+	assert.False(t, ok)
+}
+
+func TestTOTPSecret(t *testing.T) {
+	us := &MgoUserStore{collection: "user", userCfg: DefaultMgoUserCfg()}
+	ts := NewTOTPStore(us, []byte("0123456789abcdef0123456789abcdef"), "o2m")
+
+	encrypted, err := ts.encrypt([]byte("secret"), "u1")
+	assert.Nil(t, err)
+	secret, err := ts.decrypt(encrypted, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret"), secret)
+	_, err = ts.decrypt(encrypted, "u2")
+	assert.NotNil(t, err)
+
+	uri := ts.uri("13344556677", []byte("12345678901234567890"))
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/o2m:13344556677?"))
+	assert.True(t, strings.Contains(uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+
+	codes, hashes, err := newRecoveryCodes(3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(codes))
+	assert.Equal(t, 11, len(codes[0]))
+	assert.Equal(t, hashes[0], hashRecoveryCode(strings.ToUpper(codes[0])))
+}
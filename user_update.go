@@ -0,0 +1,328 @@
+// authors: wangoo
+// created: 2026-10-19
+// user profile updates with optimistic concurrency
+
+package o2m
+
+import (
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// UserPatch partial update of the fields of a user document
+type UserPatch struct {
+	// version of the user read by the caller, 0 for a user never updated by a patch
+	Version int64
+
+	// field values to set, dotted paths are allowed inside a field
+	Set bson.M
+
+	// fields to remove
+	Unset []string
+}
+
+// bson field names of a struct type, as marshalled by mgo
+func bsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for name := range bsonFieldTypes(t) {
+		names[name] = true
+	}
+	return names
+}
+
+// bson field types by name of a struct type
+func bsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	types := make(map[string]reflect.Type)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return types
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("bson")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		inline := false
+		for _, flag := range parts[1:] {
+			if flag == "inline" {
+				inline = true
+			}
+		}
+		if inline {
+			for name, ft := range bsonFieldTypes(f.Type) {
+				types[name] = ft
+			}
+			continue
+		}
+		name := parts[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		types[name] = f.Type
+	}
+	return types
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// type of a dotted path in a struct type, descending into struct fields and map values
+func bsonPathType(t reflect.Type, path []string) (reflect.Type, bool) {
+	for _, name := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			ft, ok := bsonFieldTypes(t)[name]
+			if !ok {
+				return nil, false
+			}
+			t = ft
+		case reflect.Map:
+			if t.Key().Kind() != reflect.String {
+				return nil, false
+			}
+			t = t.Elem()
+		case reflect.Interface:
+			return t, true
+		default:
+			return nil, false
+		}
+	}
+	return t, true
+}
+
+// whether a value can be stored in a field of the type and read back by mgo
+func bsonValueFits(v reflect.Value, t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return true
+	}
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		if v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return true
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if v.Type() == t {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return v.Kind() == reflect.String
+	case reflect.Bool:
+		return v.Kind() == reflect.Bool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return true
+		}
+		return false
+	case reflect.Slice, reflect.Array:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return false
+		}
+		for i := 0; i < v.Len(); i++ {
+			if !bsonValueFits(v.Index(i), t.Elem()) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		for _, k := range v.MapKeys() {
+			if !bsonValueFits(v.MapIndex(k), t.Elem()) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if t == timeType {
+			return v.Type() == timeType
+		}
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		types := bsonFieldTypes(t)
+		for _, k := range v.MapKeys() {
+			ft, ok := types[k.String()]
+			if !ok || !bsonValueFits(v.MapIndex(k), ft) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// fields which are changed only by their dedicated methods
+func (us *MgoUserStore) protectedFields() map[string]bool {
+	cfg := us.userCfg
+	protected := map[string]bool{
+		"_id":            true,
+		cfg.passwordName: true,
+		cfg.saltName:     true,
+		cfg.hashName:     true,
+		cfg.versionName:  true,
+		cfg.historyName:  true,
+
+		cfg.statusName:        true,
+		cfg.statusUpdatedName: true,
+		cfg.deletedName:       true,
+
+		// scopes are granted by the scope management
+		cfg.scopesName: true,
+	}
+	for _, idf := range cfg.identifiers {
+		protected[idf.Field] = true
+		protected[verifiedField(idf.Kind)] = true
+		protected[verifiedAtField(idf.Kind)] = true
+	}
+	return protected
+}
+
+func (us *MgoUserStore) validatePatch(patch *UserPatch) error {
+	if patch == nil || (len(patch.Set) == 0 && len(patch.Unset) == 0) {
+		return o2x.ErrValueRequired
+	}
+	fields := bsonFieldNames(us.userCfg.userType)
+	protected := us.protectedFields()
+
+	check := func(field string) error {
+		root := field
+		if idx := strings.Index(field, "."); idx >= 0 {
+			root = field[:idx]
+		}
+		if !fields[root] || protected[root] || strings.HasPrefix(field, "$") {
+			glog.Infof("user field %v cannot be updated", field)
+			return ErrInvalidUserField
+		}
+		return nil
+	}
+	for field, value := range patch.Set {
+		if err := check(field); err != nil {
+			return err
+		}
+		t, ok := bsonPathType(us.userCfg.userType, strings.Split(field, "."))
+		if !ok || !bsonValueFits(reflect.ValueOf(value), t) {
+			glog.Infof("user field %v cannot be set to %v", field, value)
+			return ErrInvalidUserField
+		}
+	}
+	for _, field := range patch.Unset {
+		if err := check(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindVersion find the user and its version, the version is read from the database and not cached
+func (us *MgoUserStore) FindVersion(id interface{}) (u o2x.User, version int64, err error) {
+	u, err = us.Find(id)
+	if err != nil {
+		return
+	}
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	doc := bson.M{}
+	err = c.FindId(u.GetUserID()).Select(bson.M{us.userCfg.versionName: 1}).One(&doc)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = o2x.ErrNotFound
+		}
+		return
+	}
+	switch v := doc[us.userCfg.versionName].(type) {
+	case int:
+		version = int64(v)
+	case int64:
+		version = v
+	}
+	return
+}
+
+// Update apply a partial update to the fields of the configured user type.
+// It returns ErrVersionConflict if the user has been updated since the patch version was read,
+// password, identifiers and version can only be changed by their dedicated methods.
+func (us *MgoUserStore) Update(id interface{}, patch *UserPatch) (err error) {
+	if err = us.validatePatch(patch); err != nil {
+		return
+	}
+	user, err := us.Find(id)
+	if err != nil {
+		return
+	}
+	glog.Infof("update user %v version %v", id, patch.Version)
+
+	session := us.session.Clone()
+	defer session.Close()
+	c := session.DB(us.db).C(us.collection)
+
+	versionName := us.userCfg.versionName
+	query := bson.M{"_id": user.GetUserID(), versionName: patch.Version}
+	if patch.Version == 0 {
+		// null also matches documents without version
+		query[versionName] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	update := bson.M{"$inc": bson.M{versionName: 1}}
+	if len(patch.Set) > 0 {
+		update["$set"] = patch.Set
+	}
+	if len(patch.Unset) > 0 {
+		unset := bson.M{}
+		for _, field := range patch.Unset {
+			unset[field] = ""
+		}
+		update["$unset"] = unset
+	}
+
+	err = c.Update(query, update)
+	if err == mgo.ErrNotFound {
+		n, countErr := c.FindId(user.GetUserID()).Count()
+		if countErr != nil {
+			return countErr
+		}
+		if n == 0 {
+			err = o2x.ErrNotFound
+		} else {
+			err = ErrVersionConflict
+		}
+	}
+	us.removeUserCache(id)
+	us.removeUserCache(user.GetUserID())
+	us.publish(id, user.GetUserID())
+	if err != nil {
+		return
+	}
+
+	_, err = us.Find(user.GetUserID())
+	return
+}
@@ -0,0 +1,72 @@
+// authors: wangoo
+// created: 2026-10-19
+// user update test
+
+package o2m
+
+import (
+	"github.com/soundbus-technologies/o2x"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+)
+
+type testProfile struct {
+	Nickname string `bson:"nickname"`
+	Avatar   string
+}
+
+type testUser struct {
+	o2x.SimpleUser `bson:",inline"`
+	Profile        testProfile       `bson:"profile"`
+	Email          string            `bson:"email,omitempty"`
+	EmailVerified  bool              `bson:"email_verified"`
+	Labels         map[string]string `bson:"labels"`
+	Internal       string            `bson:"-"`
+}
+
+func TestBsonFieldNames(t *testing.T) {
+	names := bsonFieldNames(reflect.TypeOf(&testUser{}))
+	assert.True(t, names["_id"])
+	assert.True(t, names["mobile"])
+	assert.True(t, names["profile"])
+	assert.True(t, names["email"])
+	assert.False(t, names["Internal"])
+	assert.False(t, names["internal"])
+
+	names = bsonFieldNames(reflect.TypeOf(testProfile{}))
+	assert.True(t, names["nickname"])
+	assert.True(t, names["avatar"])
+}
+
+func TestValidatePatch(t *testing.T) {
+	cfg := DefaultMgoUserCfg()
+	cfg.userType = reflect.TypeOf(&testUser{})
+	cfg.AddIdentifiers(EmailIdentifier())
+	us := &MgoUserStore{collection: "user", userCfg: cfg}
+
+	assert.Nil(t, us.validatePatch(&UserPatch{Set: bson.M{"profile.nickname": "wangoo"}}))
+	assert.Nil(t, us.validatePatch(&UserPatch{Unset: []string{"profile"}}))
+	assert.Equal(t, o2x.ErrValueRequired, us.validatePatch(&UserPatch{}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"nickname": "wangoo"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"password": "123456"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"email": "a@b.c"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Unset: []string{"_id"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"scopes.c1": "read"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Unset: []string{"scopes"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"email_verified": true}}))
+
+	// value types
+	assert.Nil(t, us.validatePatch(&UserPatch{Set: bson.M{"profile": bson.M{"nickname": "wangoo"}}}))
+	assert.Nil(t, us.validatePatch(&UserPatch{Set: bson.M{"profile": testProfile{Nickname: "wangoo"}}}))
+	assert.Nil(t, us.validatePatch(&UserPatch{Set: bson.M{"labels": bson.M{"c1": "read"}}}))
+	assert.Nil(t, us.validatePatch(&UserPatch{Set: bson.M{"labels.c1": "read"}}))
+	assert.Nil(t, us.validatePatch(&UserPatch{Set: bson.M{"profile.avatar": nil}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"profile.nickname": 5}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"labels": "x"}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"labels.c1": []string{"read"}}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"profile": bson.M{"nickname": 5}}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"profile": bson.M{"age": 5}}}))
+	assert.Equal(t, ErrInvalidUserField, us.validatePatch(&UserPatch{Set: bson.M{"profile.nickname.first": "w"}}))
+}
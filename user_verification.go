@@ -0,0 +1,392 @@
+// authors: wangoo
+// created: 2026-10-19
+// one-time tokens for password reset and identifier verification
+
+package o2m
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"math/big"
+	"time"
+)
+
+const (
+	PurposePasswordReset = "password_reset"
+
+	// prefix of the purpose verifying an identifier, followed by the identifier kind
+	purposeVerifyPrefix = "verify_"
+
+	DefaultVerificationExp         = 15 * time.Minute
+	DefaultVerificationMaxAttempts = 5
+	DefaultVerificationCodeLen     = 6
+
+	verificationTokenLen = 32
+)
+
+// VerifyPurpose the purpose of the token verifying an identifier kind
+func VerifyPurpose(kind string) string {
+	return purposeVerifyPrefix + kind
+}
+
+// the field set on the user when the identifier of the kind is verified
+func verifiedField(kind string) string {
+	return kind + "_verified"
+}
+
+func verifiedAtField(kind string) string {
+	return kind + "_verified_at"
+}
+
+// UserTokenRevoker revoke all the tokens of a user
+type UserTokenRevoker interface {
+	RemoveByAccountNoClient(userID string) error
+}
+
+// a token of a user for one purpose, only the last issued token of a purpose is valid
+type verificationToken struct {
+	// <userId>__<purpose>
+	ID      string `bson:"_id"`
+	UserID  string `bson:"user_id"`
+	Purpose string `bson:"purpose"`
+
+	// sha256 hex of the token
+	Hash string `bson:"hash"`
+
+	// the identifier value being verified
+	Target    string    `bson:"target,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiredAt time.Time `bson:"expired_at"`
+
+	// wrong attempts, kept across tokens issued before AttemptsResetAt
+	Attempts        int       `bson:"attempts"`
+	AttemptsResetAt time.Time `bson:"attempts_reset_at"`
+}
+
+// MgoVerificationStore one-time tokens of the users of a MgoUserStore, stored in <collection>_verification.
+// Tokens are stored hashed, expire, are consumed once and allow a limited number of wrong attempts.
+type MgoVerificationStore struct {
+	users       *MgoUserStore
+	session     *mgo.Session
+	db          string
+	collection  string
+	revoker     UserTokenRevoker
+	exp         time.Duration
+	maxAttempts int
+	codeLen     int
+}
+
+// NewVerificationStore create a verification store, revoker revokes the tokens of a user on password reset, can be nil
+func NewVerificationStore(users *MgoUserStore, revoker UserTokenRevoker) (vs *MgoVerificationStore) {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	vs = &MgoVerificationStore{
+		users:       users,
+		session:     users.session,
+		db:          users.db,
+		collection:  users.sideCollection(collectionVerification),
+		revoker:     revoker,
+		exp:         DefaultVerificationExp,
+		maxAttempts: DefaultVerificationMaxAttempts,
+		codeLen:     DefaultVerificationCodeLen,
+	}
+
+	err := vs.session.DB(vs.db).C(vs.collection).EnsureIndex(mgo.Index{
+		Key:         []string{"expired_at"},
+		ExpireAfter: time.Second * 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// SetExpiration set the lifetime of the tokens
+func (vs *MgoVerificationStore) SetExpiration(exp time.Duration) {
+	vs.exp = exp
+}
+
+// SetMaxAttempts set the number of wrong attempts invalidating a token
+func (vs *MgoVerificationStore) SetMaxAttempts(n int) {
+	vs.maxAttempts = n
+}
+
+// SetCodeLen set the number of digits of the codes
+func (vs *MgoVerificationStore) SetCodeLen(n int) {
+	vs.codeLen = n
+}
+
+func hashVerificationToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// random digits, sent by sms or typed by users
+func randomCode(n int) (string, error) {
+	code := make([]byte, n)
+	ten := big.NewInt(10)
+	for i := range code {
+		d, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + d.Int64())
+	}
+	return string(code), nil
+}
+
+// random url safe token, sent in links
+func randomToken() (string, error) {
+	b := make([]byte, verificationTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func verificationID(userID, purpose string) string {
+	return userID + "__" + purpose
+}
+
+func (vs *MgoVerificationStore) issue(userID, purpose, target string, code bool) (token string, err error) {
+	if code {
+		token, err = randomCode(vs.codeLen)
+	} else {
+		token, err = randomToken()
+	}
+	if err != nil {
+		return
+	}
+
+	session := vs.session.Clone()
+	defer session.Close()
+	c := session.DB(vs.db).C(vs.collection)
+
+	now := time.Now()
+	id := verificationID(userID, purpose)
+
+	// the wrong attempts are counted for all the tokens issued in one expiration window,
+	// so that issuing new tokens does not allow more guesses
+	err = c.Update(bson.M{"_id": id, "attempts_reset_at": bson.M{"$not": bson.M{"$gt": now}}},
+		bson.M{"$set": bson.M{"attempts": 0, "attempts_reset_at": now.Add(vs.exp)}})
+	if err != nil && err != mgo.ErrNotFound {
+		return "", err
+	}
+	// replace the previous token of the purpose
+	_, err = c.UpsertId(id, bson.M{
+		"$set": bson.M{
+			"user_id":    userID,
+			"purpose":    purpose,
+			"hash":       hashVerificationToken(token),
+			"target":     target,
+			"created_at": now,
+			"expired_at": now.Add(vs.exp),
+		},
+		"$setOnInsert": bson.M{"attempts": 0, "attempts_reset_at": now.Add(vs.exp)},
+	})
+	if err != nil {
+		return "", err
+	}
+	glog.Infof("issue user %v %v token", userID, purpose)
+	return
+}
+
+// consume check the token and remove it, each wrong token counts as an attempt
+func (vs *MgoVerificationStore) consume(userID, purpose, token string) (vt *verificationToken, err error) {
+	session := vs.session.Clone()
+	defer session.Close()
+	c := session.DB(vs.db).C(vs.collection)
+
+	id := verificationID(userID, purpose)
+	vt = &verificationToken{}
+	_, err = c.Find(bson.M{
+		"_id":        id,
+		"attempts":   bson.M{"$lt": vs.maxAttempts},
+		"expired_at": bson.M{"$gt": time.Now()},
+	}).Apply(mgo.Change{Update: bson.M{"$inc": bson.M{"attempts": 1}}, ReturnNew: true}, vt)
+	if err == mgo.ErrNotFound {
+		if n, _ := c.Find(bson.M{"_id": id, "attempts": bson.M{"$gte": vs.maxAttempts}}).Count(); n > 0 {
+			return nil, ErrVerificationAttemptsExceeded
+		}
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashVerificationToken(token)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(vt.Hash)) != 1 {
+		if vt.Attempts >= vs.maxAttempts {
+			glog.Infof("user %v %v token attempts exceeded", userID, purpose)
+		}
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// single use, only one of concurrent consumers removes it
+	err = c.Remove(bson.M{"_id": id, "hash": vt.Hash})
+	if err == mgo.ErrNotFound {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// restore a consumed token whose action failed, unless another token has been issued since
+func (vs *MgoVerificationStore) restore(vt *verificationToken) {
+	session := vs.session.Clone()
+	defer session.Close()
+
+	err := session.DB(vs.db).C(vs.collection).Insert(vt)
+	if err != nil && !mgo.IsDup(err) {
+		glog.Errorf("restore user %v %v token error: %v", vt.UserID, vt.Purpose, err)
+	}
+}
+
+// Revoke remove the token of the purpose of a user
+func (vs *MgoVerificationStore) Revoke(id interface{}, purpose string) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := vs.session.Clone()
+	defer session.Close()
+
+	err = session.DB(vs.db).C(vs.collection).RemoveId(verificationID(uid, purpose))
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// IssuePasswordReset issue a password reset token of a user, a numeric code if code is true,
+// otherwise a random token to be sent in a link
+func (vs *MgoVerificationStore) IssuePasswordReset(id interface{}, code bool) (token string, err error) {
+	user, err := vs.users.Find(id)
+	if err != nil {
+		return
+	}
+	return vs.issue(user.GetID(), PurposePasswordReset, "", code)
+}
+
+// ResetPassword consume the reset token, update the password and revoke all the tokens of the user.
+// The password is checked against the password policy before the token is consumed.
+func (vs *MgoVerificationStore) ResetPassword(id interface{}, token, password string) (err error) {
+	user, err := vs.users.Find(id)
+	if err != nil {
+		return
+	}
+	if err = vs.users.checkPassword(user, password); err != nil {
+		return
+	}
+	uid := user.GetID()
+	vt, err := vs.consume(uid, PurposePasswordReset, token)
+	if err != nil {
+		return
+	}
+	if err = vs.users.UpdatePwd(user.GetUserID(), password); err != nil {
+		// the token can be used again with another password
+		vs.restore(vt)
+		return
+	}
+	glog.Infof("reset user %v password", uid)
+
+	if vs.revoker != nil {
+		if err = vs.revoker.RemoveByAccountNoClient(uid); err != nil {
+			glog.Errorf("revoke user %v tokens error: %v", uid, err)
+		}
+	}
+	return
+}
+
+// IssueVerification issue a code verifying the current value of an identifier of a user
+func (vs *MgoVerificationStore) IssueVerification(id interface{}, kind string) (code string, err error) {
+	idf, err := vs.users.identifier(kind)
+	if err != nil {
+		return
+	}
+	user, err := vs.users.Find(id)
+	if err != nil {
+		return
+	}
+	doc, err := userDocument(user)
+	if err != nil {
+		return
+	}
+	target, _ := doc[idf.Field].(string)
+	if target == "" {
+		err = o2x.ErrValueRequired
+		return
+	}
+	return vs.issue(user.GetID(), VerifyPurpose(kind), target, true)
+}
+
+// Verify consume the verification code and set the verified flag <kind>_verified on the user,
+// only if the identifier is not changed since the code is issued. Pending users become active.
+func (vs *MgoVerificationStore) Verify(id interface{}, kind, code string) (err error) {
+	idf, err := vs.users.identifier(kind)
+	if err != nil {
+		return
+	}
+	user, err := vs.users.Find(id)
+	if err != nil {
+		return
+	}
+	vt, err := vs.consume(user.GetID(), VerifyPurpose(kind), code)
+	if err != nil {
+		return
+	}
+
+	session := vs.session.Clone()
+	defer session.Close()
+	c := session.DB(vs.db).C(vs.users.collection)
+
+	err = c.Update(bson.M{"_id": user.GetUserID(), idf.Field: vt.Target},
+		bson.M{"$set": bson.M{verifiedField(kind): true, verifiedAtField(kind): time.Now()}})
+	if err == mgo.ErrNotFound {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return
+	}
+	glog.Infof("verify user %v %v %v", user.GetID(), kind, vt.Target)
+
+	// a pending user is activated by the first verification
+	cfg := vs.users.userCfg
+	err = c.Update(bson.M{"_id": user.GetUserID(), cfg.statusName: UserStatusPendingVerification},
+		bson.M{"$set": bson.M{cfg.statusName: UserStatusActive, cfg.statusUpdatedName: time.Now()}})
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	vs.users.removeUserCache(id)
+	vs.users.removeUserCache(user.GetUserID())
+	vs.users.publish(id, user.GetUserID())
+	return
+}
+
+// Verified whether the identifier of the kind of a user is verified
+func (vs *MgoVerificationStore) Verified(id interface{}, kind string) (verified bool, err error) {
+	user, err := vs.users.Find(id)
+	if err != nil {
+		return
+	}
+	session := vs.session.Clone()
+	defer session.Close()
+
+	doc := bson.M{}
+	err = session.DB(vs.db).C(vs.users.collection).FindId(user.GetUserID()).Select(bson.M{verifiedField(kind): 1}).One(&doc)
+	if err == mgo.ErrNotFound {
+		err = o2x.ErrNotFound
+	}
+	verified, _ = doc[verifiedField(kind)].(bool)
+	return
+}
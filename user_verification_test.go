@@ -0,0 +1,32 @@
+// authors: wangoo
+// created: 2026-10-19
+// verification token test
+
+package o2m
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerificationToken(t *testing.T) {
+	code, err := randomCode(6)
+	assert.Nil(t, err)
+	assert.Len(t, code, 6)
+	for _, c := range code {
+		assert.True(t, c >= '0' && c <= '9')
+	}
+
+	token, err := randomToken()
+	assert.Nil(t, err)
+	assert.Len(t, token, 43)
+	other, _ := randomToken()
+	assert.NotEqual(t, token, other)
+
+	assert.Len(t, hashVerificationToken(token), 64)
+	assert.NotEqual(t, hashVerificationToken(token), hashVerificationToken(other))
+
+	assert.Equal(t, "verify_mobile", VerifyPurpose(IdentifierMobile))
+	assert.Equal(t, "mobile_verified", verifiedField(IdentifierMobile))
+	assert.Equal(t, "u1__password_reset", verificationID("u1", PurposePasswordReset))
+}
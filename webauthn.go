@@ -0,0 +1,660 @@
+// authors: wangoo
+// created: 2026-10-19
+// webauthn/passkey credential storage
+
+package o2m
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/golang/glog"
+	"github.com/soundbus-technologies/o2x"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"math/big"
+	"time"
+)
+
+const (
+	DefaultWebAuthnChallengeExp = 5 * time.Minute
+
+	webAuthnChallengeLen = 32
+
+	webAuthnCeremonyCreate = "webauthn.create"
+	webAuthnCeremonyGet    = "webauthn.get"
+
+	// authenticator data flags
+	authFlagUserPresent  = 0x01
+	authFlagUserVerified = 0x04
+	authFlagAttested     = 0x40
+
+	// cose algorithms
+	coseAlgES256 = -7
+	coseAlgRS256 = -257
+)
+
+var webAuthnEncoding = base64.RawURLEncoding
+
+// WebAuthnCredential a public key credential registered by a user
+type WebAuthnCredential struct {
+	// base64url credential id
+	ID         string    `bson:"_id" json:"id"`
+	UserID     string    `bson:"user_id" json:"user_id"`
+	PublicKey  []byte    `bson:"public_key" json:"-"` // cose key
+	Algorithm  int64     `bson:"alg" json:"alg"`
+	SignCount  uint32    `bson:"sign_count" json:"sign_count"`
+	Transports []string  `bson:"transports,omitempty" json:"transports,omitempty"`
+	AAGUID     []byte    `bson:"aaguid,omitempty" json:"aaguid,omitempty"`
+	Name       string    `bson:"name,omitempty" json:"name,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// a challenge waiting for the response of a ceremony
+type webAuthnChallenge struct {
+	Challenge string    `bson:"_id"`
+	UserID    string    `bson:"user_id,omitempty"`
+	Ceremony  string    `bson:"ceremony"`
+	ExpiredAt time.Time `bson:"expired_at"`
+}
+
+type WebAuthnCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// WebAuthnCreationOptions public key options of navigator.credentials.create, binary values in base64url
+type WebAuthnCreationOptions struct {
+	Challenge          string                         `json:"challenge"`
+	RP                 WebAuthnRelyingParty           `json:"rp"`
+	User               WebAuthnUser                   `json:"user"`
+	PubKeyCredParams   []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout            int64                          `json:"timeout"`
+	ExcludeCredentials []WebAuthnCredentialDescriptor `json:"excludeCredentials,omitempty"`
+	Attestation        string                         `json:"attestation"`
+}
+
+// WebAuthnRequestOptions public key options of navigator.credentials.get, binary values in base64url
+type WebAuthnRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	RPID             string                         `json:"rpId"`
+	Timeout          int64                          `json:"timeout"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnAttestationResponse registration response, binary values in base64url
+type WebAuthnAttestationResponse struct {
+	ID                string   `json:"id"`
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// WebAuthnAssertionResponse authentication response, binary values in base64url
+type WebAuthnAssertionResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// MgoWebAuthnStore webauthn credentials of the users of a MgoUserStore,
+// stored in <collection>_webauthn with challenges in <collection>_webauthn_challenge.
+// Attestation statements are not verified, as with the "none" attestation conveyance.
+type MgoWebAuthnStore struct {
+	users               *MgoUserStore
+	session             *mgo.Session
+	db                  string
+	collection          string
+	challengeCollection string
+	rp                  WebAuthnRelyingParty
+	origins             []string
+	challengeExp        time.Duration
+}
+
+// NewWebAuthnStore create a credential store for the relying party, origins are the allowed client origins
+func NewWebAuthnStore(users *MgoUserStore, rp WebAuthnRelyingParty, origins ...string) (ws *MgoWebAuthnStore) {
+	if users == nil {
+		panic("user store cannot be nil")
+	}
+	if rp.ID == "" || len(origins) == 0 {
+		panic("relying party id and origins required")
+	}
+	if rp.Name == "" {
+		rp.Name = rp.ID
+	}
+	ws = &MgoWebAuthnStore{
+		users:               users,
+		session:             users.session,
+		db:                  users.db,
+		collection:          users.sideCollection(collectionWebAuthn),
+		challengeCollection: users.sideCollection(collectionWebAuthnChallenge),
+		rp:                  rp,
+		origins:             origins,
+		challengeExp:        DefaultWebAuthnChallengeExp,
+	}
+
+	err := ws.session.DB(ws.db).C(ws.collection).EnsureIndex(mgo.Index{
+		Key: []string{"user_id"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = ws.session.DB(ws.db).C(ws.challengeCollection).EnsureIndex(mgo.Index{
+		Key:         []string{"expired_at"},
+		ExpireAfter: time.Second * 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (ws *MgoWebAuthnStore) newChallenge(userID, ceremony string) (challenge string, err error) {
+	b := make([]byte, webAuthnChallengeLen)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	challenge = webAuthnEncoding.EncodeToString(b)
+
+	session := ws.session.Clone()
+	defer session.Close()
+	err = session.DB(ws.db).C(ws.challengeCollection).Insert(&webAuthnChallenge{
+		Challenge: challenge,
+		UserID:    userID,
+		Ceremony:  ceremony,
+		ExpiredAt: time.Now().Add(ws.challengeExp),
+	})
+	return
+}
+
+// remove the challenge so that it is used only once
+func (ws *MgoWebAuthnStore) consumeChallenge(challenge, ceremony string) (ch *webAuthnChallenge, err error) {
+	session := ws.session.Clone()
+	defer session.Close()
+	c := session.DB(ws.db).C(ws.challengeCollection)
+
+	ch = &webAuthnChallenge{}
+	_, err = c.Find(bson.M{"_id": challenge, "ceremony": ceremony}).Apply(mgo.Change{Remove: true}, ch)
+	if err == mgo.ErrNotFound {
+		return nil, ErrWebAuthnChallenge
+	}
+	if err == nil && ch.ExpiredAt.Before(time.Now()) {
+		return nil, ErrWebAuthnChallenge
+	}
+	return
+}
+
+// check the client data and consume its challenge
+func (ws *MgoWebAuthnStore) verifyClientData(clientDataJSON []byte, ceremony string) (ch *webAuthnChallenge, err error) {
+	cd := &webAuthnClientData{}
+	if err = json.Unmarshal(clientDataJSON, cd); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	if cd.Type != ceremony {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	allowed := false
+	for _, origin := range ws.origins {
+		if cd.Origin == origin {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		glog.Infof("webauthn origin %v not allowed", cd.Origin)
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	return ws.consumeChallenge(cd.Challenge, ceremony)
+}
+
+func parseAuthenticatorData(data []byte) (ad *authenticatorData, err error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	ad = &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.flags&authFlagAttested == 0 {
+		return
+	}
+
+	data = data[37:]
+	if len(data) < 18 {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	ad.aaguid = data[:16]
+	n := int(binary.BigEndian.Uint16(data[16:18]))
+	data = data[18:]
+	if len(data) < n {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	ad.credentialID = data[:n]
+	data = data[n:]
+	if _, rest, err := decodeCBOR(data); err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	} else {
+		ad.publicKey = data[:len(data)-len(rest)]
+	}
+	return
+}
+
+func (ws *MgoWebAuthnStore) verifyAuthenticatorData(ad *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(ws.rp.ID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return ErrInvalidWebAuthnResponse
+	}
+	if ad.flags&authFlagUserPresent == 0 {
+		return ErrInvalidWebAuthnResponse
+	}
+	return nil
+}
+
+func coseInt(key map[interface{}]interface{}, label int64) (int64, bool) {
+	v, ok := key[label].(int64)
+	return v, ok
+}
+
+func coseBytes(key map[interface{}]interface{}, label int64) []byte {
+	b, _ := key[label].([]byte)
+	return b
+}
+
+// parseCOSEKey decode an ES256 or RS256 cose key
+func parseCOSEKey(data []byte) (pub crypto.PublicKey, alg int64, err error) {
+	v, _, err := decodeCBOR(data)
+	if err != nil {
+		return
+	}
+	key, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrUnsupportedCOSEKey
+	}
+	kty, _ := coseInt(key, 1)
+	alg, _ = coseInt(key, 3)
+
+	switch {
+	case kty == 2 && alg == coseAlgES256:
+		if crv, _ := coseInt(key, -1); crv != 1 {
+			return nil, 0, ErrUnsupportedCOSEKey
+		}
+		x, y := coseBytes(key, -2), coseBytes(key, -3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUnsupportedCOSEKey
+		}
+		ecKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return nil, 0, ErrUnsupportedCOSEKey
+		}
+		return ecKey, alg, nil
+	case kty == 3 && alg == coseAlgRS256:
+		n, e := coseBytes(key, -1), coseBytes(key, -2)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUnsupportedCOSEKey
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, ErrUnsupportedCOSEKey
+}
+
+// verify an assertion signature over authenticatorData || sha256(clientDataJSON)
+func verifyWebAuthnSignature(coseKey, authData, clientDataJSON, signature []byte) error {
+	pub, _, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return ErrInvalidWebAuthnResponse
+		}
+		if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return ErrInvalidWebAuthnResponse
+		}
+		return nil
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidWebAuthnResponse
+		}
+		return nil
+	}
+	return ErrUnsupportedCOSEKey
+}
+
+// whether the counter did not increase, a sign of a cloned authenticator.
+// Authenticators without counter always return 0.
+func signCountRegressed(stored, received uint32) bool {
+	if stored == 0 && received == 0 {
+		return false
+	}
+	return received <= stored
+}
+
+func decodeWebAuthn(values ...string) (decoded [][]byte, err error) {
+	for _, v := range values {
+		b, err := webAuthnEncoding.DecodeString(v)
+		if err != nil {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+		decoded = append(decoded, b)
+	}
+	return
+}
+
+func credentialDescriptors(creds []*WebAuthnCredential) (descriptors []WebAuthnCredentialDescriptor) {
+	for _, cred := range creds {
+		descriptors = append(descriptors, WebAuthnCredentialDescriptor{
+			Type:       "public-key",
+			ID:         cred.ID,
+			Transports: cred.Transports,
+		})
+	}
+	return
+}
+
+// BeginRegistration create the options to register a new credential of the user
+func (ws *MgoWebAuthnStore) BeginRegistration(id interface{}) (options *WebAuthnCreationOptions, err error) {
+	user, err := ws.users.Find(id)
+	if err != nil {
+		return
+	}
+	uid := user.GetID()
+	creds, err := ws.ListCredentials(uid)
+	if err != nil {
+		return
+	}
+	challenge, err := ws.newChallenge(uid, webAuthnCeremonyCreate)
+	if err != nil {
+		return
+	}
+
+	name := user.GetMobile()
+	if name == "" {
+		name = uid
+	}
+	options = &WebAuthnCreationOptions{
+		Challenge: challenge,
+		RP:        ws.rp,
+		User: WebAuthnUser{
+			ID:          webAuthnEncoding.EncodeToString([]byte(uid)),
+			Name:        name,
+			DisplayName: name,
+		},
+		PubKeyCredParams: []WebAuthnCredentialParameter{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:            int64(ws.challengeExp / time.Millisecond),
+		ExcludeCredentials: credentialDescriptors(creds),
+		Attestation:        "none",
+	}
+	return
+}
+
+// FinishRegistration verify the registration response and store the credential with a friendly name
+func (ws *MgoWebAuthnStore) FinishRegistration(id interface{}, name string, resp *WebAuthnAttestationResponse) (cred *WebAuthnCredential, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	decoded, err := decodeWebAuthn(resp.ID, resp.ClientDataJSON, resp.AttestationObject)
+	if err != nil {
+		return
+	}
+	credID, clientDataJSON, attestationObject := decoded[0], decoded[1], decoded[2]
+
+	ch, err := ws.verifyClientData(clientDataJSON, webAuthnCeremonyCreate)
+	if err != nil {
+		return
+	}
+	if ch.UserID != uid {
+		return nil, ErrWebAuthnChallenge
+	}
+
+	v, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	att, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	authData, _ := att["authData"].([]byte)
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return
+	}
+	if err = ws.verifyAuthenticatorData(ad); err != nil {
+		return
+	}
+	if ad.credentialID == nil || !bytes.Equal(ad.credentialID, credID) {
+		return nil, ErrInvalidWebAuthnResponse
+	}
+	_, alg, err := parseCOSEKey(ad.publicKey)
+	if err != nil {
+		return
+	}
+
+	cred = &WebAuthnCredential{
+		ID:         webAuthnEncoding.EncodeToString(credID),
+		UserID:     uid,
+		PublicKey:  ad.publicKey,
+		Algorithm:  alg,
+		SignCount:  ad.signCount,
+		Transports: resp.Transports,
+		AAGUID:     ad.aaguid,
+		Name:       name,
+		CreatedAt:  time.Now(),
+	}
+
+	session := ws.session.Clone()
+	defer session.Close()
+	err = session.DB(ws.db).C(ws.collection).Insert(cred)
+	if mgo.IsDup(err) {
+		return nil, ErrWebAuthnCredentialExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("register user %v webauthn credential %v", uid, cred.ID)
+	return
+}
+
+// BeginLogin create the options to authenticate, id is nil for discoverable credentials
+func (ws *MgoWebAuthnStore) BeginLogin(id interface{}) (options *WebAuthnRequestOptions, err error) {
+	var uid string
+	var creds []*WebAuthnCredential
+	if id != nil {
+		if uid, err = o2x.UserIdString(id); err != nil {
+			return
+		}
+		if creds, err = ws.ListCredentials(uid); err != nil {
+			return
+		}
+		if len(creds) == 0 {
+			return nil, o2x.ErrNotFound
+		}
+	}
+	challenge, err := ws.newChallenge(uid, webAuthnCeremonyGet)
+	if err != nil {
+		return
+	}
+	options = &WebAuthnRequestOptions{
+		Challenge:        challenge,
+		RPID:             ws.rp.ID,
+		Timeout:          int64(ws.challengeExp / time.Millisecond),
+		AllowCredentials: credentialDescriptors(creds),
+		UserVerification: "preferred",
+	}
+	return
+}
+
+// FinishLogin verify the assertion and return the credential, whose UserID is the authenticated user.
+// ErrSignCountRegression is returned if the signature counter did not increase,
+// ErrUserSuspended or o2x.ErrNotFound if the user is suspended or deleted.
+func (ws *MgoWebAuthnStore) FinishLogin(resp *WebAuthnAssertionResponse) (cred *WebAuthnCredential, err error) {
+	decoded, err := decodeWebAuthn(resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature)
+	if err != nil {
+		return
+	}
+	clientDataJSON, authData, signature := decoded[0], decoded[1], decoded[2]
+
+	ch, err := ws.verifyClientData(clientDataJSON, webAuthnCeremonyGet)
+	if err != nil {
+		return
+	}
+
+	session := ws.session.Clone()
+	defer session.Close()
+	c := session.DB(ws.db).C(ws.collection)
+
+	cred = &WebAuthnCredential{}
+	if err = c.FindId(resp.ID).One(cred); err != nil {
+		if err == mgo.ErrNotFound {
+			err = ErrInvalidWebAuthnResponse
+		}
+		return nil, err
+	}
+	if ch.UserID != "" && ch.UserID != cred.UserID {
+		return nil, ErrWebAuthnChallenge
+	}
+	if resp.UserHandle != "" {
+		if handle, err := webAuthnEncoding.DecodeString(resp.UserHandle); err != nil || string(handle) != cred.UserID {
+			return nil, ErrInvalidWebAuthnResponse
+		}
+	}
+
+	// suspended and deleted users cannot login
+	if _, err = ws.users.Find(cred.UserID); err != nil {
+		return nil, err
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if err = ws.verifyAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if err = verifyWebAuthnSignature(cred.PublicKey, authData, clientDataJSON, signature); err != nil {
+		return nil, err
+	}
+	if signCountRegressed(cred.SignCount, ad.signCount) {
+		glog.Warningf("user %v webauthn credential %v sign count regressed from %v to %v", cred.UserID, cred.ID, cred.SignCount, ad.signCount)
+		return nil, ErrSignCountRegression
+	}
+
+	// only if not used meanwhile by the same counter
+	now := time.Now()
+	err = c.Update(bson.M{"_id": cred.ID, "sign_count": cred.SignCount},
+		bson.M{"$set": bson.M{"sign_count": ad.signCount, "last_used_at": now}})
+	if err == mgo.ErrNotFound {
+		return nil, ErrSignCountRegression
+	}
+	if err != nil {
+		return nil, err
+	}
+	cred.SignCount = ad.signCount
+	cred.LastUsedAt = now
+	return
+}
+
+// ListCredentials list the credentials of a user
+func (ws *MgoWebAuthnStore) ListCredentials(id interface{}) (creds []*WebAuthnCredential, err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ws.session.Clone()
+	defer session.Close()
+
+	err = session.DB(ws.db).C(ws.collection).Find(bson.M{"user_id": uid}).Sort("created_at").All(&creds)
+	return
+}
+
+// RenameCredential change the friendly name of a credential of the user
+func (ws *MgoWebAuthnStore) RenameCredential(id interface{}, credentialID, name string) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ws.session.Clone()
+	defer session.Close()
+
+	err = session.DB(ws.db).C(ws.collection).Update(bson.M{"_id": credentialID, "user_id": uid},
+		bson.M{"$set": bson.M{"name": name}})
+	if err == mgo.ErrNotFound {
+		err = o2x.ErrNotFound
+	}
+	return
+}
+
+// RemoveCredential remove a credential of the user
+func (ws *MgoWebAuthnStore) RemoveCredential(id interface{}, credentialID string) (err error) {
+	uid, err := o2x.UserIdString(id)
+	if err != nil {
+		return
+	}
+	session := ws.session.Clone()
+	defer session.Close()
+
+	err = session.DB(ws.db).C(ws.collection).Remove(bson.M{"_id": credentialID, "user_id": uid})
+	if err == mgo.ErrNotFound {
+		err = o2x.ErrNotFound
+	}
+	if err == nil {
+		glog.Infof("remove user %v webauthn credential %v", uid, credentialID)
+	}
+	return
+}
@@ -0,0 +1,189 @@
+// authors: wangoo
+// created: 2026-10-19
+// webauthn test
+
+package o2m
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+// minimal cbor encoder for the test vectors
+func cborHead(major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	}
+}
+
+func cborEncode(v interface{}) (b []byte) {
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return cborHead(1, -1-x)
+		}
+		return cborHead(0, x)
+	case []byte:
+		return append(cborHead(2, len(x)), x...)
+	case string:
+		return append(cborHead(3, len(x)), x...)
+	case []interface{}:
+		b = cborHead(4, len(x))
+		for _, item := range x {
+			b = append(b, cborEncode(item)...)
+		}
+		return
+	case [][2]interface{}:
+		// ordered map entries
+		b = cborHead(5, len(x))
+		for _, kv := range x {
+			b = append(b, cborEncode(kv[0])...)
+			b = append(b, cborEncode(kv[1])...)
+		}
+		return
+	}
+	panic("unsupported cbor value")
+}
+
+func padded(i *big.Int, n int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, n-len(b)), b...)
+}
+
+func ecCOSEKey(pub *ecdsa.PublicKey) []byte {
+	return cborEncode([][2]interface{}{
+		{1, 2}, {3, coseAlgES256}, {-1, 1},
+		{-2, padded(pub.X, 32)}, {-3, padded(pub.Y, 32)},
+	})
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	data := cborEncode([][2]interface{}{
+		{"fmt", "none"},
+		{"n", []interface{}{0, 23, 24, 500, -1, -257}},
+		{"b", []byte{1, 2}},
+	})
+	v, rest, err := decodeCBOR(append(data, 0xff))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff}, rest)
+	m := v.(map[interface{}]interface{})
+	assert.Equal(t, "none", m["fmt"])
+	assert.Equal(t, []interface{}{int64(0), int64(23), int64(24), int64(500), int64(-1), int64(-257)}, m["n"])
+	assert.Equal(t, []byte{1, 2}, m["b"])
+
+	// truncated
+	_, _, err = decodeCBOR(data[:len(data)-1])
+	assert.Equal(t, ErrInvalidCBOR, err)
+	// length beyond the data
+	_, _, err = decodeCBOR([]byte{0x5a, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, ErrInvalidCBOR, err)
+	// too deep
+	deep := make([]byte, cborMaxDepth+2)
+	for i := range deep {
+		deep[i] = 0x81
+	}
+	_, _, err = decodeCBOR(deep)
+	assert.Equal(t, ErrInvalidCBOR, err)
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, alg, err := parseCOSEKey(ecCOSEKey(&ecKey.PublicKey))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(coseAlgES256), alg)
+	assert.Equal(t, 0, pub.(*ecdsa.PublicKey).X.Cmp(ecKey.X))
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pub, alg, err = parseCOSEKey(cborEncode([][2]interface{}{
+		{1, 3}, {3, coseAlgRS256},
+		{-1, rsaKey.N.Bytes()}, {-2, big.NewInt(int64(rsaKey.E)).Bytes()},
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(coseAlgRS256), alg)
+	assert.Equal(t, rsaKey.E, pub.(*rsa.PublicKey).E)
+
+	// EdDSA is not supported
+	_, _, err = parseCOSEKey(cborEncode([][2]interface{}{{1, 1}, {3, -8}, {-1, 6}, {-2, make([]byte, 32)}}))
+	assert.Equal(t, ErrUnsupportedCOSEKey, err)
+	// point not on the curve
+	_, _, err = parseCOSEKey(cborEncode([][2]interface{}{
+		{1, 2}, {3, coseAlgES256}, {-1, 1}, {-2, make([]byte, 32)}, {-3, make([]byte, 32)},
+	}))
+	assert.Equal(t, ErrUnsupportedCOSEKey, err)
+}
+
+func TestParseAuthenticatorData(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	coseKey := ecCOSEKey(&ecKey.PublicKey)
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+	credID := []byte("credential-id")
+
+	data := append(rpIDHash[:], authFlagUserPresent|authFlagAttested, 0, 0, 0, 7)
+	data = append(data, make([]byte, 16)...)
+	data = append(data, 0, byte(len(credID)))
+	data = append(data, credID...)
+	data = append(data, coseKey...)
+
+	ad, err := parseAuthenticatorData(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(7), ad.signCount)
+	assert.Equal(t, credID, ad.credentialID)
+	assert.Equal(t, coseKey, ad.publicKey)
+
+	ws := &MgoWebAuthnStore{rp: WebAuthnRelyingParty{ID: "example.com"}}
+	assert.Nil(t, ws.verifyAuthenticatorData(ad))
+	ws.rp.ID = "evil.com"
+	assert.Equal(t, ErrInvalidWebAuthnResponse, ws.verifyAuthenticatorData(ad))
+
+	_, err = parseAuthenticatorData(data[:50])
+	assert.Equal(t, ErrInvalidWebAuthnResponse, err)
+}
+
+func TestVerifyWebAuthnSignature(t *testing.T) {
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+	authData := append(rpIDHash[:], authFlagUserPresent, 0, 0, 0, 1)
+	clientDataJSON := []byte(`{"type":"webauthn.get","challenge":"abc","origin":"https://example.com"}`)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	sig, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	coseKey := ecCOSEKey(&ecKey.PublicKey)
+	assert.Nil(t, verifyWebAuthnSignature(coseKey, authData, clientDataJSON, sig))
+	assert.Equal(t, ErrInvalidWebAuthnResponse, verifyWebAuthnSignature(coseKey, authData, []byte("{}"), sig))
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	sig, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	coseKey = cborEncode([][2]interface{}{
+		{1, 3}, {3, coseAlgRS256},
+		{-1, rsaKey.N.Bytes()}, {-2, big.NewInt(int64(rsaKey.E)).Bytes()},
+	})
+	assert.Nil(t, verifyWebAuthnSignature(coseKey, authData, clientDataJSON, sig))
+	sig[0] ^= 0xff
+	assert.Equal(t, ErrInvalidWebAuthnResponse, verifyWebAuthnSignature(coseKey, authData, clientDataJSON, sig))
+}
+
+func TestSignCountRegressed(t *testing.T) {
+	assert.False(t, signCountRegressed(0, 0))
+	assert.False(t, signCountRegressed(0, 1))
+	assert.False(t, signCountRegressed(5, 6))
+	assert.True(t, signCountRegressed(5, 5))
+	assert.True(t, signCountRegressed(5, 0))
+}